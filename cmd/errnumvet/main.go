@@ -0,0 +1,16 @@
+// Command errnumvet reports the errors returned without the error number wrapper.
+//
+// Usage:
+//
+//	go vet -vettool=$(which errnumvet) ./...
+//	errnumvet -fix ./...
+package main
+
+import (
+	"github.com/anjankow/errnumgen/pkg/analyzer"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	singlechecker.Main(analyzer.Analyzer)
+}
@@ -1,28 +1,59 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
+	"github.com/anjankow/errnumgen/pkg/diff"
 	"github.com/anjankow/errnumgen/pkg/errparser"
+	"github.com/anjankow/errnumgen/pkg/explain"
 	"github.com/anjankow/errnumgen/pkg/generator"
 )
 
 var (
 	outputPackage = flag.String("out-pkg", "errnums", "Output package; defaults to errnums")
 	outputFile    = flag.String("out-file", "", "Output file name; defaults to <input-dir>/<output-package>/errnums.go")
-	skipPaths     = flag.String("skip", "", "Comma separated list of files or directories to skip")
-	dryRun        = flag.Bool("dry", false, "Dry run - print the changes to be made to stdout")
+	outputImport  = flag.String("out-import", "", "Import path of the output package, added to the updated files; defaults to the one found from the closest go.mod")
+	skipPaths     = flag.String("skip", "", "Comma separated list of files or directories to skip; glob patterns with ** and regular expressions prefixed with re: are accepted")
+	includePaths  = flag.String("include", "", "Comma separated list of files or directories to parse, the same way as skip; if given, other files are skipped")
+	inclGenerated = flag.Bool("include-generated", false, "Parse the generated files too, recognized by the \"// Code generated ... DO NOT EDIT.\" header")
+	inclVendor    = flag.Bool("include-vendor", false, "Parse the files within the vendor directories too")
+	verbose       = flag.Bool("v", false, "Verbose output, e.g. report which skip and include rules matched which files")
+	dryRun        = flag.Bool("dry", false, "Dry run - print the changes to be made to stdout as a unified diff, accepted by git apply")
+	patchFile     = flag.String("patch", "", "Write the changes to be made as a unified diff to the given file instead of stdout; implies dry run")
+	check         = flag.Bool("check", false, "Check mode - list the error sites that would be wrapped or renumbered and fail if there are any; nothing is written")
 	backup        = flag.Bool("bkp", true, "Backup the source files before overwriting; used only if dry-run is set to false")
+	typeAware     = flag.Bool("types", false, "Use the type information to find all error results, e.g. aliases of error; slower")
+	warnConcrete  = flag.Bool("warn-concrete", false, "Log the results of concrete error types that can't be wrapped; used only if types is set to true")
+	tests         = flag.Bool("tests", false, "Include the test files and the external test packages")
+	classes       = flag.String("classes", "", "Comma separated list of error classes to number: fresh, wrapping, sentinel, pass-through, call, unknown; defaults to all")
+	constPrefix   = flag.String("const-prefix", "N_", "Prefix of the error number consts, e.g. N_ of N_12; must form exported Go identifiers")
+	codeWidth     = flag.Int("code-width", 0, "Minimal number of digits of each number in the runtime code, padded with zeros")
+	numPrefix     = flag.String("num-prefix", "", "Prefix of each number in the runtime code, e.g. E of E0042")
+	codeSep       = flag.String("code-sep", "-", "Separator of the numbers in the runtime code")
+	codePrefix    = flag.String("code-prefix", "", "Prefix of the whole runtime code, e.g. PAY- of PAY-E0042.E0007")
+	templatePath  = flag.String("template", "", "Template of the output file, or a directory of templates each rendering the file of its name without .tmpl next to the output file; defaults to the embedded template")
+	buildConfigs  = flag.String("build", "", "Semicolon separated list of build configurations to load the packages with, each as [goos/goarch][:tag1,tag2]; e.g. linux/amd64;windows/amd64:integration")
 )
 
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("errnumgen: ")
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		if err := explainCmd(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	flag.Parse()
 	logFlags := "flags: "
 	flag.VisitAll(func(f *flag.Flag) {
@@ -56,6 +87,15 @@ func run(dir string) error {
 		// Set to the default if not given
 		gopts.OutPath = filepath.Join(dir, gopts.OutPackageName, "errnums.go")
 	}
+	gopts.OutImportPath = *outputImport
+	// Nothing is written, the output package import path may be unknown
+	gopts.DryRun = *dryRun || *patchFile != "" || *check
+	gopts.ConstPrefix = *constPrefix
+	gopts.CodeWidth = *codeWidth
+	gopts.NumPrefix = *numPrefix
+	gopts.CodeSeparator = *codeSep
+	gopts.CodePrefix = *codePrefix
+	gopts.Template = *templatePath
 
 	// Initialize the errnum generator
 	g, err := generator.New(gopts)
@@ -64,14 +104,42 @@ func run(dir string) error {
 	}
 
 	popts := errparser.GetDefaultOptions()
-	// Use the generator's callback to process the error params
+	// Use the generator's callbacks to process the error params and record the parsed files
 	popts.RetParamParser = g.ParseRetParam
-	popts.SkipPaths = []string{gopts.OutPath}
+	popts.FileParser = g.ParseFile
+	popts.TypeAware = *typeAware
+	popts.Tests = *tests
+	popts.BuildConfigs, err = parseBuildConfigs(*buildConfigs)
+	if err != nil {
+		return err
+	}
+	if *warnConcrete {
+		popts.ConcreteErrors = errparser.ConcreteErrorWarn
+	}
+	// Skip all the rendered files, not only the output file
+	popts.SkipPaths = append([]string{gopts.OutPath}, g.OutputPaths()...)
 	for p := range strings.SplitSeq(*skipPaths, ",") {
 		if p != "" {
 			popts.SkipPaths = append(popts.SkipPaths, p)
 		}
 	}
+	for c := range strings.SplitSeq(*classes, ",") {
+		if c == "" {
+			continue
+		}
+		class, err := errparser.ParseErrorClass(c)
+		if err != nil {
+			return err
+		}
+		popts.Classes = append(popts.Classes, class)
+	}
+	popts.IncludeGenerated = *inclGenerated
+	popts.IncludeVendor = *inclVendor
+	for p := range strings.SplitSeq(*includePaths, ",") {
+		if p != "" {
+			popts.IncludePaths = append(popts.IncludePaths, p)
+		}
+	}
 	// Initialize the parser
 	p, err := errparser.New(dir, popts)
 	if err != nil {
@@ -84,39 +152,77 @@ func run(dir string) error {
 		return err
 	}
 
-	// And generate the output
+	if *verbose {
+		for _, match := range p.RuleMatches() {
+			switch {
+			case match.Rule == "":
+				log.Default().Printf("skipped %s: no include rule matched", match.Filename)
+			case match.Skipped:
+				log.Default().Printf("skipped %s: matched skip rule %q", match.Filename, match.Rule)
+			default:
+				log.Default().Printf("included %s: matched include rule %q", match.Filename, match.Rule)
+			}
+		}
+		for _, site := range parsed {
+			log.Default().Printf("found %s", site)
+		}
+	}
+
+	if *check {
+		return checkSites(&g, parsed)
+	}
+
+	// And generate the output, the registry records the already wrapped errors too
 	updated, outputFilename, err := g.Generate(parsed)
 	if err != nil {
 		return err
 	}
 
+	registry, err := g.Registry()
+	if err != nil {
+		return err
+	}
+	registryFilename := g.RegistryPath()
+
 	log.Default().Println("output file: ", outputFilename)
-	log.Default().Println("num of updated files: ", len(updated)-1)
+	log.Default().Println("registry file: ", registryFilename)
+	log.Default().Println("num of updated files: ", len(updated)-len(g.OutputPaths()))
+	updated[registryFilename] = registry
 
-	if *dryRun {
-		fmt.Println("=== OUTPUT FILE ===")
-		fmt.Println(updated[outputFilename])
-		delete(updated, outputFilename)
-		fmt.Println()
+	summary := g.Summary()
+	log.Default().Printf("errors: %d added, %d renumbered, %d skipped, %d numbers retired",
+		summary.Added, summary.Renumbered, summary.Skipped, summary.Retired)
 
-		fmt.Println("=== SOURCE FILES ===")
-		for file, content := range updated {
-			fmt.Println("---> ", file)
-			fmt.Println(content)
-			fmt.Println()
+	if *dryRun || *patchFile != "" {
+		patch, err := makePatch(updated)
+		if err != nil {
+			return err
+		}
+		if *patchFile == "" {
+			fmt.Print(patch)
+			return nil
+		}
+		if err := os.WriteFile(*patchFile, []byte(patch), 0664); err != nil {
+			return fmt.Errorf("failed to write the patch file %q: %w", *patchFile, err)
 		}
+		log.Default().Println("patch file: ", *patchFile)
 		return nil
 	}
 
-	// Write the files to the disk
-	outputFileContent := updated[outputFilename]
+	// Write the files to the disk, the rendered ones without the backup
 	if err := os.MkdirAll(filepath.Dir(outputFilename), 0775); err != nil {
 		return fmt.Errorf("failed to create output directory %q: %w", outputFilename, err)
 	}
-	if err := os.WriteFile(outputFilename, []byte(outputFileContent), 0664); err != nil {
-		return fmt.Errorf("failed to write the output file %q: %w", outputFilename, err)
+	for _, filename := range g.OutputPaths() {
+		if err := os.WriteFile(filename, []byte(updated[filename]), 0664); err != nil {
+			return fmt.Errorf("failed to write the output file %q: %w", filename, err)
+		}
+		delete(updated, filename)
+	}
+	if err := os.WriteFile(registryFilename, []byte(registry), 0664); err != nil {
+		return fmt.Errorf("failed to write the registry file %q: %w", registryFilename, err)
 	}
-	delete(updated, outputFilename)
+	delete(updated, registryFilename)
 
 	for filename, content := range updated {
 		st, err := os.Stat(filename)
@@ -142,6 +248,189 @@ func run(dir string) error {
 	return nil
 }
 
+// makePatch returns the unified diff of all updated files,
+// with the paths relative to the current directory
+func makePatch(updated map[string]string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the current directory: %w", err)
+	}
+
+	var patch strings.Builder
+	for _, filename := range slices.Sorted(maps.Keys(updated)) {
+		file := diff.File{New: updated[filename]}
+		content, err := os.ReadFile(filename)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			file.Created = true
+		case err != nil:
+			return "", fmt.Errorf("failed to read the file %q: %w", filename, err)
+		}
+		file.Old = string(content)
+
+		name, err := filepath.Rel(wd, filename)
+		if err != nil {
+			name = filename
+		}
+		file.Name = filepath.ToSlash(name)
+		patch.WriteString(diff.Unified(file))
+	}
+	return patch.String(), nil
+}
+
+// checkSites lists the error sites that would be changed by the generator,
+// it fails if there are any
+func checkSites(g *generator.Generator, sites []errparser.ErrorSite) error {
+	// The new numbers are assigned after the issued ones, as when generating
+	if err := g.ReadOutputFile(); err != nil {
+		return err
+	}
+	edits, err := g.Edits(sites)
+	if err != nil {
+		return err
+	}
+	for _, edit := range edits {
+		fmt.Println(edit)
+	}
+	if len(edits) > 0 {
+		return fmt.Errorf("found %d error sites to wrap or renumber", len(edits))
+	}
+	return nil
+}
+
+// explainCmd finds the errors of the code returned by the generated Code function:
+// errnumgen explain [flags] <code> [dir]
+func explainCmd(args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: errnumgen explain [flags] <code> [dir]")
+		fmt.Fprintln(flags.Output(), "Prints the errors of the code, e.g. 34-12-7, from the outermost to the innermost one.")
+		flags.PrintDefaults()
+	}
+	outPkg := flags.String("out-pkg", "errnums", "Output package; defaults to errnums")
+	outFile := flags.String("out-file", "", "Output file name, the registry is read next to it; defaults to <dir>/<output-package>/errnums.go")
+	constPrefix := flags.String("const-prefix", "N_", "Prefix of the error number consts, e.g. N_ of N_12")
+	numPrefix := flags.String("num-prefix", "", "Prefix of each number in the code, e.g. E of E0042")
+	codeSep := flags.String("code-sep", "-", "Separator of the numbers in the code")
+	codePrefix := flags.String("code-prefix", "", "Prefix of the whole code, e.g. PAY- of PAY-E0042.E0007")
+	context := flags.Int("C", 2, "Number of the source lines printed around each error")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return errors.New("expected the error code and optionally the directory")
+	}
+	code, dir := flags.Arg(0), "."
+	if flags.NArg() == 2 {
+		dir = flags.Arg(1)
+	}
+
+	opts := explain.GetDefaultOptions()
+	opts.OutPackageName = *outPkg
+	opts.ConstPrefix = *constPrefix
+	opts.NumPrefix = *numPrefix
+	opts.CodeSeparator = *codeSep
+	opts.CodePrefix = *codePrefix
+	opts.Context = *context
+	links, err := explain.Explain(dir, code, opts)
+	if err != nil {
+		return err
+	}
+
+	// The numbers not found in the source tree are looked up in the registry
+	gopts := generator.GetDefaultGenOptions()
+	gopts.OutPackageName = *outPkg
+	gopts.ConstPrefix = *constPrefix
+	// Only the registry path is needed
+	gopts.DryRun = true
+	gopts.OutPath = filepath.Join(dir, *outPkg, "errnums.go")
+	if *outFile != "" {
+		gopts.OutPath = *outFile
+	}
+	g, err := generator.New(gopts)
+	if err != nil {
+		return err
+	}
+	registry, err := generator.ReadRegistry(os.ReadFile, g.RegistryPath())
+	if err != nil {
+		return err
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get the current directory: %w", err)
+	}
+	printLinks(os.Stdout, links, registry, g.RegistryPath(), wd)
+	return nil
+}
+
+// printLinks prints the wrapper calls of the error chain with their source lines,
+// the paths are relative to the given directory
+func printLinks(w io.Writer, links []explain.Link, registry generator.Registry, registryPath string, wd string) {
+	relPath := func(filename string) string {
+		if rel, err := filepath.Rel(wd, filename); err == nil {
+			return rel
+		}
+		return filename
+	}
+
+	for i, link := range links {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if len(link.Calls) == 0 {
+			entry, ok := registry.Lookup(link.Num)
+			if !ok {
+				fmt.Fprintf(w, "%d: not found\n", link.Num)
+				continue
+			}
+			filename := filepath.Join(filepath.Dir(registryPath), filepath.FromSlash(entry.File))
+			fmt.Fprintf(w, "%d: not found in the source tree, last recorded at %s:%d in %s\n\t%s\n",
+				link.Num, relPath(filename), entry.Line, entry.Function, entry.Expression)
+			continue
+		}
+		for _, call := range link.Calls {
+			fmt.Fprintf(w, "%d: %s:%d in %s\n", link.Num, relPath(call.Pos.Filename), call.Pos.Line, call.FuncName)
+			for _, line := range call.Lines {
+				marker := " "
+				if line.Num == call.Pos.Line {
+					marker = ">"
+				}
+				fmt.Fprintf(w, "%s %5d\t%s\n", marker, line.Num, line.Text)
+			}
+		}
+	}
+}
+
+// parseBuildConfigs parses the build configurations given as
+// [goos/goarch][:tag1,tag2] and separated by semicolons
+func parseBuildConfigs(s string) ([]errparser.BuildConfig, error) {
+	var configs []errparser.BuildConfig
+	for c := range strings.SplitSeq(s, ";") {
+		if c == "" {
+			continue
+		}
+
+		var config errparser.BuildConfig
+		platform, tags, _ := strings.Cut(c, ":")
+		if platform != "" {
+			goos, goarch, ok := strings.Cut(platform, "/")
+			if !ok || goos == "" || goarch == "" {
+				return nil, fmt.Errorf("invalid build configuration %q, expected goos/goarch", c)
+			}
+			config.GOOS, config.GOARCH = goos, goarch
+		}
+		for tag := range strings.SplitSeq(tags, ",") {
+			if tag != "" {
+				config.Tags = append(config.Tags, tag)
+			}
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
 // isDirectory reports whether the named file is a directory.
 func isDirectory(name string) bool {
 	info, err := os.Stat(name)
@@ -2,9 +2,9 @@ module github.com/anjankow/errnumgen
 
 go 1.25.1
 
-require golang.org/x/tools v0.39.0
-
 require (
-	golang.org/x/mod v0.30.0 // indirect
-	golang.org/x/sync v0.18.0 // indirect
+	golang.org/x/mod v0.30.0
+	golang.org/x/tools v0.39.0
 )
+
+require golang.org/x/sync v0.18.0 // indirect
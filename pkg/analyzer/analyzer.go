@@ -0,0 +1,181 @@
+// Package analyzer reports the errors returned without the error number wrapper.
+// It can be run with go vet -vettool, singlechecker, golangci-lint or gopls.
+package analyzer
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/anjankow/errnumgen/pkg/errparser"
+	"github.com/anjankow/errnumgen/pkg/generator"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/packages"
+)
+
+const doc = `report errors returned without the errnums.New wrapper
+
+The errors returned by the functions, including the function literals, the bare returns,
+the forwarded calls and the errors assigned to the named results in the deferred functions,
+should be wrapped with the error number, e.g. errnums.New(errnums.N_12, err).
+The suggested fixes add the wrapper and the import of the output package, if its import path is known.
+
+Each package is analyzed separately, the suggested numbers start after the highest number
+declared by the imported output package or used within the analyzed package. So the numbers
+suggested for different packages may collide, and the consts of the new numbers are declared
+only by the regenerated output file; run errnumgen to number the whole module at once.`
+
+// Analyzer reports the errors returned without the error number wrapper
+var Analyzer = &analysis.Analyzer{
+	Name: "errnumgen",
+	Doc:  doc,
+	Run:  run,
+}
+
+var (
+	outPackageName   string
+	outImportPath    string
+	constPrefix      string
+	includeGenerated bool
+)
+
+func init() {
+	Analyzer.Flags.StringVar(&outPackageName, "out-pkg", generator.GetDefaultGenOptions().OutPackageName, "Name of the package holding the error numbers")
+	Analyzer.Flags.StringVar(&outImportPath, "out-import", "", "Import path of the package holding the error numbers; defaults to the imported package named out-pkg")
+	Analyzer.Flags.StringVar(&constPrefix, "const-prefix", generator.GetDefaultGenOptions().ConstPrefix, "Prefix of the error number consts, e.g. N_ of N_12")
+	Analyzer.Flags.BoolVar(&includeGenerated, "include-generated", false, "Report the generated files too")
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	outPkg := outPackage(pass.Pkg)
+
+	gopts := generator.GetDefaultGenOptions()
+	gopts.OutPackageName = outPackageName
+	gopts.OutImportPath = outImportPath
+	gopts.ConstPrefix = constPrefix
+	if gopts.OutImportPath == "" && outPkg != nil {
+		gopts.OutImportPath = outPkg.Path()
+	}
+	gopts.Reader = pass.ReadFile
+	// The analyzer doesn't update the files
+	gopts.DryRun = true
+	g, err := generator.New(gopts)
+	if err != nil {
+		return nil, err
+	}
+	if outPkg != nil {
+		g.ReserveNums(declaredNums(&g, outPkg))
+	}
+
+	// The parser works on the loaded packages, the pass provides the same information
+	pkg := &packages.Package{
+		ID:        pass.Pkg.Path(),
+		Name:      pass.Pkg.Name(),
+		PkgPath:   pass.Pkg.Path(),
+		Fset:      pass.Fset,
+		Syntax:    pass.Files,
+		Types:     pass.Pkg,
+		TypesInfo: pass.TypesInfo,
+	}
+	popts := errparser.GetDefaultOptions()
+	// Use the generator's callback to skip the already wrapped errors
+	popts.RetParamParser = g.ParseRetParam
+	popts.TypeAware = true
+	popts.IncludeGenerated = includeGenerated
+	p, err := errparser.NewFromPackages([]*packages.Package{pkg}, popts)
+	if err != nil {
+		return nil, err
+	}
+	sites, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	edits, err := g.Edits(sites)
+	if err != nil {
+		return nil, err
+	}
+	for _, edit := range edits {
+		diag := diagnostic(edit)
+		// Without the import path the fix couldn't import the output package
+		if gopts.OutImportPath != "" {
+			fix, err := suggestedFix(pass, &g, edit)
+			if err != nil {
+				return nil, err
+			}
+			diag.SuggestedFixes = []analysis.SuggestedFix{fix}
+		}
+		pass.Report(diag)
+	}
+	return nil, nil
+}
+
+// diagnostic reports the site of the edit
+func diagnostic(edit generator.Edit) analysis.Diagnostic {
+	site := edit.Site
+	message := fmt.Sprintf("%s of %s error is not wrapped with %s.New", site.Kind, site.Class, outPackageName)
+	if edit.Renumber {
+		message = fmt.Sprintf("error number differs from the pinned one: %v", site.Codes)
+	}
+
+	return analysis.Diagnostic{
+		Pos:     site.Node.Pos(),
+		End:     site.Node.End(),
+		Message: message,
+	}
+}
+
+// suggestedFix suggests the edit as the fix, together with the import of the output package
+// if the file doesn't import it yet, as done by the generator
+func suggestedFix(pass *analysis.Pass, g *generator.Generator, edit generator.Edit) (analysis.SuggestedFix, error) {
+	site := edit.Site
+	fix := analysis.SuggestedFix{
+		Message: fmt.Sprintf("Wrap with %s.New", outPackageName),
+		TextEdits: []analysis.TextEdit{{
+			Pos:     site.Node.Pos(),
+			End:     site.Node.End(),
+			NewText: []byte(edit.NewText),
+		}},
+	}
+	if edit.Renumber {
+		fix.Message = "Use the pinned number"
+	}
+
+	importEdit, found, err := g.ImportEdit(site.Pos.Filename)
+	if err != nil || !found {
+		return fix, err
+	}
+	file := pass.Fset.File(site.Node.Pos())
+	fix.TextEdits = append(fix.TextEdits, analysis.TextEdit{
+		Pos:     file.Pos(importEdit.Offset),
+		End:     file.Pos(importEdit.End),
+		NewText: []byte(importEdit.NewText),
+	})
+	return fix, nil
+}
+
+// outPackage returns the output package imported by the analyzed package, nil if it's not imported
+func outPackage(pkg *types.Package) *types.Package {
+	for _, imp := range pkg.Imports() {
+		if outImportPath != "" && imp.Path() == outImportPath {
+			return imp
+		}
+		if outImportPath == "" && imp.Name() == outPackageName {
+			return imp
+		}
+	}
+	return nil
+}
+
+// declaredNums returns the highest error number declared by the output package
+func declaredNums(g *generator.Generator, outPkg *types.Package) int {
+	var highest int
+	for _, name := range outPkg.Scope().Names() {
+		if _, ok := outPkg.Scope().Lookup(name).(*types.Const); !ok {
+			continue
+		}
+		if num, ok := g.NumFromName(name); ok {
+			highest = max(highest, num)
+		}
+	}
+	return highest
+}
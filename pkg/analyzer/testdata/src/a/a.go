@@ -0,0 +1,34 @@
+package a
+
+import (
+	"errors"
+	"os"
+
+	"errnums"
+)
+
+var errNotFound = errors.New("not found")
+
+func find(name string) (string, error) {
+	if name == "" {
+		return "", errnums.New(errnums.N_1, errNotFound)
+	}
+	if name == "missing" {
+		return "", errNotFound // want `return of sentinel error is not wrapped with errnums.New`
+	}
+	return name, nil
+}
+
+func open(name string) (f *os.File, err error) {
+	f, err = os.Open(name)
+	return // want `bare return of pass-through error is not wrapped with errnums.New`
+}
+
+func load(name string) (string, error) {
+	return find(name) // want `tuple return of call error is not wrapped with errnums.New`
+}
+
+func pinned() error {
+	//errnumgen:code 7
+	return errnums.New(errnums.N_2, errNotFound) // want `error number differs from the pinned one: \[7\]`
+}
@@ -0,0 +1,22 @@
+package b
+
+import (
+	"errors"
+
+	en "errnums"
+)
+
+type recorder struct{}
+
+func (recorder) New(code int, err error) error {
+	return nil
+}
+
+func wrapped() error {
+	return en.New(en.N_1, errors.New("wrapped"))
+}
+
+func shadowed(err error) error {
+	errnums := recorder{}
+	return errnums.New(1, err) // want `return of call error is not wrapped with errnums.New`
+}
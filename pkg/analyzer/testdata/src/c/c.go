@@ -0,0 +1,14 @@
+package c
+
+import (
+	"errors"
+
+	"errnums"
+)
+
+func check(s string) error {
+	if s == "" {
+		return errnums.New(errnums.N_1, errors.New("empty"))
+	}
+	return nil
+}
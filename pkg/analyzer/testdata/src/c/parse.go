@@ -0,0 +1,10 @@
+package c
+
+import "errors"
+
+func parse(s string) error {
+	if err := check(s); err != nil {
+		return err // want `return of pass-through error is not wrapped with errnums.New`
+	}
+	return errors.New("not implemented") // want `return of fresh error is not wrapped with errnums.New`
+}
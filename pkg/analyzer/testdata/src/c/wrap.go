@@ -0,0 +1,6 @@
+package c
+
+// The output package is imported by the other files of the package only
+func wrap(err error) error {
+	return err // want `return of pass-through error is not wrapped with errnums.New`
+}
@@ -0,0 +1,12 @@
+package errnums
+
+type ErrNum int
+
+const (
+	N_1 ErrNum = 1
+	N_2 ErrNum = 2
+)
+
+func New(num ErrNum, err error) error {
+	return err
+}
@@ -0,0 +1,253 @@
+// Package diff creates the unified diffs of the updated files, in the format accepted by git apply
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// context is the number of the unchanged lines around each change
+const context = 3
+
+// File is a file changed by the generator
+type File struct {
+	// Name is the slash separated path of the file, relative to the directory the patch is applied in
+	Name string
+	// Old is the original content, empty if the file is created
+	Old string
+	// New is the updated content
+	New string
+	// Created is set if the file doesn't exist yet
+	Created bool
+}
+
+// Unified returns the unified diff of the file, with the git header;
+// empty if the content didn't change
+func Unified(f File) string {
+	if f.Old == f.New && !f.Created {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff --git a/%s b/%s\n", f.Name, f.Name)
+	if f.Created {
+		b.WriteString("new file mode 100644\n")
+		b.WriteString("--- /dev/null\n")
+	} else {
+		fmt.Fprintf(&b, "--- a/%s\n", f.Name)
+	}
+	fmt.Fprintf(&b, "+++ b/%s\n", f.Name)
+
+	ops := lineOps(splitLines(f.Old), splitLines(f.New))
+	for _, h := range hunks(ops) {
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", h.oldRange(), h.newRange())
+		for _, o := range h.ops {
+			b.WriteByte(o.kind)
+			b.WriteString(o.line)
+			if !strings.HasSuffix(o.line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// splitLines splits the content into lines, keeping the line endings
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+const (
+	opEqual  = ' '
+	opDelete = '-'
+	opInsert = '+'
+)
+
+// op is a line of the diff
+type op struct {
+	kind byte
+	line string
+}
+
+// lineOps returns the operations changing the old lines into the new ones,
+// found with the Myers' algorithm
+func lineOps(a, b []string) []op {
+	// The common prefix and suffix are not searched
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{opEqual, line})
+	}
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{opEqual, line})
+	}
+	return ops
+}
+
+// myers returns the shortest edit script changing a into b
+func myers(a, b []string) []op {
+	n, m := len(a), len(b)
+	maxD := n + m
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3)
+
+	// trace holds v before each step, to find the path back
+	var trace [][]int
+	d := 0
+search:
+	for ; d <= maxD; d++ {
+		trace = append(trace, slices.Clone(v))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Go back from the end, collecting the operations in the reversed order
+	var ops []op
+	x, y := n, m
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, op{opEqual, a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			ops = append(ops, op{opInsert, b[y-1]})
+			y--
+		} else {
+			ops = append(ops, op{opDelete, a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, op{opEqual, a[x-1]})
+		x--
+		y--
+	}
+	slices.Reverse(ops)
+	return ops
+}
+
+// hunk is a group of the changes close to each other, with their context
+type hunk struct {
+	ops []op
+	// oldStart and newStart are the numbers of the lines preceding the hunk
+	oldStart, newStart int
+}
+
+func (h hunk) oldRange() string {
+	return lineRange(h.oldStart, h.count(opDelete))
+}
+
+func (h hunk) newRange() string {
+	return lineRange(h.newStart, h.count(opInsert))
+}
+
+// count returns the number of the hunk lines, either the unchanged ones or of the given kind
+func (h hunk) count(kind byte) int {
+	cnt := 0
+	for _, o := range h.ops {
+		if o.kind == opEqual || o.kind == kind {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// lineRange formats the range of the hunk lines; an empty range starts at the preceding line
+func lineRange(precedingLines, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", precedingLines)
+	case 1:
+		return fmt.Sprintf("%d", precedingLines+1)
+	default:
+		return fmt.Sprintf("%d,%d", precedingLines+1, count)
+	}
+}
+
+// hunks groups the changes, merging the ones separated by at most twice the context
+func hunks(ops []op) []hunk {
+	// The numbers of the old and new lines preceding each operation
+	oldLines := make([]int, len(ops)+1)
+	newLines := make([]int, len(ops)+1)
+	for i, o := range ops {
+		oldLines[i+1], newLines[i+1] = oldLines[i], newLines[i]
+		if o.kind != opInsert {
+			oldLines[i+1]++
+		}
+		if o.kind != opDelete {
+			newLines[i+1]++
+		}
+	}
+
+	var hs []hunk
+	for i := 0; i < len(ops); {
+		// Find the next change
+		for i < len(ops) && ops[i].kind == opEqual {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		// Find the last change close enough to the previous one
+		last := i
+		for j := i; j < len(ops) && j-last <= 2*context+1; j++ {
+			if ops[j].kind != opEqual {
+				last = j
+			}
+		}
+
+		start := max(i-context, 0)
+		end := min(last+context+1, len(ops))
+		hs = append(hs, hunk{
+			ops:      ops[start:end],
+			oldStart: oldLines[start],
+			newStart: newLines[start],
+		})
+		i = end
+	}
+	return hs
+}
@@ -0,0 +1,89 @@
+package diff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anjankow/errnumgen/pkg/diff"
+)
+
+func TestUnified(t *testing.T) {
+	lines := func(from, to int, replace map[int]string) string {
+		var b strings.Builder
+		for i := from; i <= to; i++ {
+			if r, ok := replace[i]; ok {
+				b.WriteString(r)
+				continue
+			}
+			b.WriteString("line " + string(rune('a'+i)) + "\n")
+		}
+		return b.String()
+	}
+
+	for _, tc := range []struct {
+		name string
+		file diff.File
+		exp  string
+	}{
+		{
+			name: "unchanged",
+			file: diff.File{Name: "same.go", Old: "a\n", New: "a\n"},
+			exp:  "",
+		},
+		{
+			name: "created",
+			file: diff.File{Name: "errnums/errnums.go", New: "package errnums\n", Created: true},
+			exp: "diff --git a/errnums/errnums.go b/errnums/errnums.go\n" +
+				"new file mode 100644\n" +
+				"--- /dev/null\n" +
+				"+++ b/errnums/errnums.go\n" +
+				"@@ -0,0 +1 @@\n" +
+				"+package errnums\n",
+		},
+		{
+			// The changes separated by more than twice the context are in separate hunks
+			name: "hunks",
+			file: diff.File{
+				Name: "pkg/file.go",
+				Old:  lines(0, 15, nil),
+				New:  lines(0, 15, map[int]string{1: "changed b\n", 13: "changed n\nadded\n"}),
+			},
+			exp: "diff --git a/pkg/file.go b/pkg/file.go\n" +
+				"--- a/pkg/file.go\n" +
+				"+++ b/pkg/file.go\n" +
+				"@@ -1,5 +1,5 @@\n" +
+				" line a\n" +
+				"-line b\n" +
+				"+changed b\n" +
+				" line c\n" +
+				" line d\n" +
+				" line e\n" +
+				"@@ -11,6 +11,7 @@\n" +
+				" line k\n" +
+				" line l\n" +
+				" line m\n" +
+				"-line n\n" +
+				"+changed n\n" +
+				"+added\n" +
+				" line o\n" +
+				" line p\n",
+		},
+		{
+			name: "no newline at end",
+			file: diff.File{Name: "a.go", Old: "a\nb", New: "a\nc"},
+			exp: "diff --git a/a.go b/a.go\n" +
+				"--- a/a.go\n" +
+				"+++ b/a.go\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" a\n" +
+				"-b\n" +
+				"\\ No newline at end of file\n" +
+				"+c\n" +
+				"\\ No newline at end of file\n",
+		},
+	} {
+		if found := diff.Unified(tc.file); found != tc.exp {
+			t.Errorf("%s: expected diff:\n%s\nfound:\n%s", tc.name, tc.exp, found)
+		}
+	}
+}
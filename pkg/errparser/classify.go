@@ -0,0 +1,194 @@
+package errparser
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// ErrorClass tells where the error passed to the caller comes from
+type ErrorClass int
+
+const (
+	// ClassUnknown is an error expression that could not be classified
+	ClassUnknown ErrorClass = iota
+	// ClassFresh is a newly created error: errors.New("..."), fmt.Errorf without %w or &MyError{}
+	ClassFresh
+	// ClassWrapping is a newly created error wrapping other errors: fmt.Errorf with %w or errors.Join
+	ClassWrapping
+	// ClassSentinel is a reference to a package level error variable, e.g. errInvalidPath or io.EOF
+	ClassSentinel
+	// ClassPassThrough is a local variable, a parameter or a field, e.g. err
+	ClassPassThrough
+	// ClassCall is the error returned by another call, e.g. decodeMessage(&b, m)
+	ClassCall
+)
+
+var classNames = []string{
+	ClassUnknown:     "unknown",
+	ClassFresh:       "fresh",
+	ClassWrapping:    "wrapping",
+	ClassSentinel:    "sentinel",
+	ClassPassThrough: "pass-through",
+	ClassCall:        "call",
+}
+
+func (c ErrorClass) String() string {
+	if int(c) < len(classNames) {
+		return classNames[c]
+	}
+	return fmt.Sprintf("ErrorClass(%d)", int(c))
+}
+
+// ParseErrorClass returns the class of the given name, as returned by ErrorClass.String
+func ParseErrorClass(name string) (ErrorClass, error) {
+	for c, n := range classNames {
+		if n == name {
+			return ErrorClass(c), nil
+		}
+	}
+	return ClassUnknown, fmt.Errorf("unknown error class %q, expected one of: %s", name, strings.Join(classNames[1:], ", "))
+}
+
+// classify returns the class of the error expression.
+// With the type information the identifiers are resolved,
+// otherwise the package level variables and the imports of the file are looked up by name.
+func (g *Parser) classify(pkg *packages.Package, expr ast.Expr) ErrorClass {
+	switch e := ast.Unparen(expr).(type) {
+	case *ast.CallExpr:
+		return g.classifyCall(pkg, e)
+	case *ast.Ident:
+		if g.isPkgVar(pkg, e) {
+			return ClassSentinel
+		}
+		return ClassPassThrough
+	case *ast.SelectorExpr:
+		if g.isPkgVar(pkg, e.Sel) {
+			return ClassSentinel
+		}
+		if x, ok := e.X.(*ast.Ident); ok && !g.hasTypes(pkg) && g.isImported(x.Name) {
+			return ClassSentinel
+		}
+		return ClassPassThrough
+	case *ast.IndexExpr, *ast.StarExpr, *ast.TypeAssertExpr:
+		return ClassPassThrough
+	case *ast.CompositeLit:
+		return ClassFresh
+	case *ast.UnaryExpr:
+		if _, ok := e.X.(*ast.CompositeLit); ok && e.Op == token.AND {
+			return ClassFresh
+		}
+	}
+	return ClassUnknown
+}
+
+// classifyCall returns the class of the error returned by the call
+func (g *Parser) classifyCall(pkg *packages.Package, call *ast.CallExpr) ErrorClass {
+	switch g.calledFunc(pkg, call) {
+	case "errors.New":
+		return ClassFresh
+	case "errors.Join":
+		return ClassWrapping
+	case "fmt.Errorf":
+		if len(call.Args) == 0 {
+			return ClassCall
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			// The format is not known
+			return ClassCall
+		}
+		format, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return ClassCall
+		}
+		if strings.Contains(format, "%w") {
+			return ClassWrapping
+		}
+		return ClassFresh
+	default:
+		return ClassCall
+	}
+}
+
+// calledFunc returns the name of the called package function qualified with the import path,
+// e.g. fmt.Errorf; empty if it's not a package function
+func (g *Parser) calledFunc(pkg *packages.Package, call *ast.CallExpr) string {
+	sel, ok := ast.Unparen(call.Fun).(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	if g.hasTypes(pkg) {
+		fn, ok := pkg.TypesInfo.Uses[sel.Sel].(*types.Func)
+		if !ok || fn.Pkg() == nil || fn.Type().(*types.Signature).Recv() != nil {
+			return ""
+		}
+		return fn.Pkg().Path() + "." + fn.Name()
+	}
+
+	x, ok := sel.X.(*ast.Ident)
+	if !ok || !g.isImported(x.Name) {
+		return ""
+	}
+	return x.Name + "." + sel.Sel.Name
+}
+
+// isPkgVar reports whether the identifier refers to a package level variable
+func (g *Parser) isPkgVar(pkg *packages.Package, ident *ast.Ident) bool {
+	if g.hasTypes(pkg) {
+		v, ok := pkg.TypesInfo.Uses[ident].(*types.Var)
+		return ok && v.Pkg() != nil && v.Parent() == v.Pkg().Scope()
+	}
+	return g.pkgVars[ident.Name]
+}
+
+// hasTypes reports whether the type information of the package is loaded
+func (g *Parser) hasTypes(pkg *packages.Package) bool {
+	return g.typeAware && pkg.TypesInfo != nil
+}
+
+// isImported reports whether the name refers to a package imported by the current file.
+// The package name is assumed to be the last element of its import path.
+func (g *Parser) isImported(name string) bool {
+	if g.file == nil {
+		return false
+	}
+	for _, imp := range g.file.Imports {
+		if imp.Name != nil {
+			if imp.Name.Name == name {
+				return true
+			}
+			continue
+		}
+		impPath, err := strconv.Unquote(imp.Path.Value)
+		if err == nil && path.Base(impPath) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// collectPkgVars returns the names of the package level variables declared in the package files
+func collectPkgVars(pkg *packages.Package) map[string]bool {
+	vars := make(map[string]bool)
+	for _, stxFile := range pkg.Syntax {
+		for _, decl := range stxFile.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					vars[name.Name] = true
+				}
+			}
+		}
+	}
+	return vars
+}
@@ -0,0 +1,143 @@
+package errparser
+
+import (
+	"go/ast"
+	"go/token"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The directives are comments starting with the prefix, placed on the same line
+// as the statement or on the line above it:
+//
+//	//errnumgen:ignore       - skip the statement, function or file (if placed in the file doc)
+//	//errnumgen:file-ignore  - skip the whole file, can be placed anywhere in the file
+//	//errnumgen:code 1042    - use the given error number; multiple numbers are assigned in order
+//	                           to the errors returned by the statement
+const directivePrefix = "//errnumgen:"
+
+const (
+	directiveIgnore     = "ignore"
+	directiveFileIgnore = "file-ignore"
+	directiveCode       = "code"
+)
+
+type directive struct {
+	name string
+	args []string
+	pos  token.Pos
+}
+
+// fileDirectives holds the directives found in a file
+type fileDirectives struct {
+	fset       *token.FileSet
+	ignoreFile bool
+	// lines maps the line number to the directives placed on it
+	lines map[int][]directive
+}
+
+// parseDirectives finds all the directives within the file comments
+func parseDirectives(fset *token.FileSet, stxFile *ast.File) fileDirectives {
+	dirs := fileDirectives{
+		fset:  fset,
+		lines: make(map[int][]directive),
+	}
+	for _, group := range stxFile.Comments {
+		for _, comment := range group.List {
+			d, ok := parseDirective(comment)
+			if !ok {
+				continue
+			}
+
+			switch d.name {
+			case directiveFileIgnore:
+				dirs.ignoreFile = true
+			case directiveIgnore:
+				if group == stxFile.Doc {
+					dirs.ignoreFile = true
+				}
+			case directiveCode:
+			default:
+				log.Default().Printf("%s - unknown directive %q", fset.Position(d.pos), comment.Text)
+				continue
+			}
+
+			line := fset.Position(d.pos).Line
+			dirs.lines[line] = append(dirs.lines[line], d)
+		}
+	}
+	return dirs
+}
+
+func parseDirective(comment *ast.Comment) (directive, bool) {
+	text, ok := strings.CutPrefix(comment.Text, directivePrefix)
+	if !ok {
+		return directive{}, false
+	}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return directive{}, false
+	}
+	return directive{
+		name: fields[0],
+		args: fields[1:],
+		pos:  comment.Pos(),
+	}, true
+}
+
+// lookup returns the directives placed on the line of the node or on the line above
+func (d fileDirectives) lookup(node ast.Node) []directive {
+	if len(d.lines) == 0 {
+		return nil
+	}
+	line := d.fset.Position(node.Pos()).Line
+	return slices.Concat(d.lines[line-1], d.lines[line])
+}
+
+// ignored reports whether the node is marked with the ignore directive
+func (d fileDirectives) ignored(node ast.Node) bool {
+	for _, dir := range d.lookup(node) {
+		if dir.name == directiveIgnore {
+			return true
+		}
+	}
+	return false
+}
+
+// codes returns the error numbers pinned to the node with the code directive
+func (d fileDirectives) codes(node ast.Node) []int {
+	var codes []int
+	for _, dir := range d.lookup(node) {
+		if dir.name != directiveCode {
+			continue
+		}
+		if len(dir.args) == 0 {
+			log.Default().Printf("%s - missing error number in the code directive", d.fset.Position(dir.pos))
+		}
+		for _, arg := range dir.args {
+			code, err := strconv.Atoi(arg)
+			if err != nil || code <= 0 {
+				log.Default().Printf("%s - invalid error number %q in the code directive", d.fset.Position(dir.pos), arg)
+				continue
+			}
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// hasIgnoreDirective reports whether the comment group contains the ignore directive,
+// e.g. a function doc
+func hasIgnoreDirective(group *ast.CommentGroup) bool {
+	if group == nil {
+		return false
+	}
+	for _, comment := range group.List {
+		if d, ok := parseDirective(comment); ok && d.name == directiveIgnore {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package errparser
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// regexPrefix marks the path rule as a regular expression
+const regexPrefix = "re:"
+
+// vendorRule matches the vendor directories
+const vendorRule = "**/vendor"
+
+// generatedRule is reported as the rule matching the generated files
+const generatedRule = "// Code generated ... DO NOT EDIT."
+
+// generatedHeader matches the comment marking the generated files, see https://go.dev/s/generatedcode
+var generatedHeader = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// isGenerated reports whether the file content starts with the generated code header.
+// The header must appear before the package clause.
+func isGenerated(data []byte) bool {
+	for line := range bytes.Lines(data) {
+		line = bytes.TrimRight(line, "\r\n")
+		if generatedHeader.Match(line) {
+			return true
+		}
+		if bytes.HasPrefix(line, []byte("package ")) {
+			return false
+		}
+	}
+	return false
+}
+
+// PathRule matches the file paths. The pattern is one of:
+//   - a regular expression prefixed with "re:", matched against the absolute, slash separated file path,
+//     e.g. `re:_gen\.go$`
+//   - a glob pattern, where `**` matches any number of directories, e.g. `**/*_gen.go` or `**/mocks`;
+//     unless it starts with `**` or `/`, it's relative to the current working directory
+//   - a path of a file or a directory, relative to the current working directory or absolute
+//
+// The glob patterns and the paths match the file itself and any of its parent directories,
+// so a directory matches all the files within it.
+type PathRule struct {
+	pattern string
+	// abs is the absolute, slash separated path or glob pattern
+	abs  string
+	glob bool
+	re   *regexp.Regexp
+}
+
+// NewPathRule parses the path rule pattern
+func NewPathRule(pattern string) (PathRule, error) {
+	rule := PathRule{pattern: pattern}
+
+	if expr, ok := strings.CutPrefix(pattern, regexPrefix); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return PathRule{}, fmt.Errorf("invalid regular expression %q: %w", expr, err)
+		}
+		rule.re = re
+		return rule, nil
+	}
+
+	rule.glob = strings.ContainsAny(pattern, "*?[")
+	if rule.glob {
+		// Check the syntax of each segment
+		for _, segment := range strings.Split(filepath.ToSlash(pattern), "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return PathRule{}, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+			}
+		}
+	}
+
+	if rule.glob && strings.HasPrefix(pattern, "**") {
+		rule.abs = filepath.ToSlash(pattern)
+		return rule, nil
+	}
+	abs, err := filepath.Abs(pattern)
+	if err != nil {
+		return PathRule{}, fmt.Errorf("invalid path %q, can't create an absolute path: %w", pattern, err)
+	}
+	rule.abs = filepath.ToSlash(abs)
+	return rule, nil
+}
+
+// Match reports whether the rule matches the absolute file path
+func (r PathRule) Match(filename string) bool {
+	filename = filepath.ToSlash(filename)
+	if r.re != nil {
+		return r.re.MatchString(filename)
+	}
+	if !r.glob {
+		return filename == r.abs || strings.HasPrefix(filename, strings.TrimSuffix(r.abs, "/")+"/")
+	}
+
+	// Match the file or any of its parent directories
+	patternSegments := strings.Split(r.abs, "/")
+	pathSegments := strings.Split(filename, "/")
+	for i := len(pathSegments); i > 0; i-- {
+		if matchSegments(patternSegments, pathSegments[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches the path segments against the glob pattern segments,
+// where `**` matches zero or more segments
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try to match the rest of the pattern with each remaining suffix
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+func (r PathRule) String() string {
+	return r.pattern
+}
+
+// RuleMatch describes the decision made about a file by the skip and include rules
+type RuleMatch struct {
+	Filename string
+	// Rule is the matched rule; empty if no include rule matched the file
+	Rule string
+	// Skipped is true if the file is not parsed
+	Skipped bool
+}
+
+// pathMatcher decides which files are skipped based on the skip and include rules.
+// It's safe for the concurrent use.
+type pathMatcher struct {
+	skip    []PathRule
+	include []PathRule
+
+	mu sync.Mutex
+	// matches holds the rules matched by each file
+	matches map[string]RuleMatch
+}
+
+func newPathMatcher(skipPatterns, includePatterns []string) (*pathMatcher, error) {
+	m := &pathMatcher{matches: make(map[string]RuleMatch)}
+	for _, p := range skipPatterns {
+		rule, err := NewPathRule(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid skip rule: %w", err)
+		}
+		m.skip = append(m.skip, rule)
+	}
+	for _, p := range includePatterns {
+		rule, err := NewPathRule(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include rule: %w", err)
+		}
+		m.include = append(m.include, rule)
+	}
+	return m, nil
+}
+
+// shouldSkip reports whether the file should not be parsed.
+// The skip rules take precedence over the include rules.
+// If any include rules are given, only the files matching one of them are parsed.
+func (m *pathMatcher) shouldSkip(filename string) bool {
+	match, matched := m.match(filename)
+	if matched {
+		m.mu.Lock()
+		m.matches[filename] = match
+		m.mu.Unlock()
+	}
+	return match.Skipped
+}
+
+func (m *pathMatcher) match(filename string) (match RuleMatch, matched bool) {
+	match.Filename = filename
+	for _, rule := range m.skip {
+		if rule.Match(filename) {
+			match.Rule = rule.String()
+			match.Skipped = true
+			return match, true
+		}
+	}
+
+	if len(m.include) == 0 {
+		return match, false
+	}
+	for _, rule := range m.include {
+		if rule.Match(filename) {
+			match.Rule = rule.String()
+			return match, true
+		}
+	}
+	match.Skipped = true
+	return match, true
+}
+
+// skipGenerated records the generated file as skipped
+func (m *pathMatcher) skipGenerated(filename string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.matches[filename] = RuleMatch{
+		Filename: filename,
+		Rule:     generatedRule,
+		Skipped:  true,
+	}
+}
+
+// ruleMatches returns the matches ordered by the file name
+func (m *pathMatcher) ruleMatches() []RuleMatch {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matches := make([]RuleMatch, 0, len(m.matches))
+	for _, match := range m.matches {
+		matches = append(matches, match)
+	}
+	slices.SortFunc(matches, func(a, b RuleMatch) int {
+		return cmp.Compare(a.Filename, b.Filename)
+	})
+	return matches
+}
@@ -0,0 +1,56 @@
+package errparser_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/anjankow/errnumgen/pkg/errparser"
+)
+
+func TestPathRuleMatch(t *testing.T) {
+	abs := func(p string) string {
+		a, err := filepath.Abs(p)
+		if err != nil {
+			t.Fatalf("failed to get the absolute path: %v", err)
+		}
+		return a
+	}
+
+	for _, tc := range []struct {
+		pattern  string
+		filename string
+		expMatch bool
+	}{
+		// Paths match the file and the files within the directory
+		{pattern: "./api", filename: abs("api/server.go"), expMatch: true},
+		{pattern: "./api", filename: abs("api_v2/server.go"), expMatch: false},
+		{pattern: "./api/server.go", filename: abs("api/server.go"), expMatch: true},
+		{pattern: abs("api"), filename: abs("api/v1/server.go"), expMatch: true},
+		// Glob patterns
+		{pattern: "**/*_gen.go", filename: "/src/app/model_gen.go", expMatch: true},
+		{pattern: "**/*_gen.go", filename: "/src/app/model.go", expMatch: false},
+		{pattern: "**/mocks", filename: "/src/app/mocks/db.go", expMatch: true},
+		{pattern: "**/mocks/**", filename: "/src/app/mocks/nested/db.go", expMatch: true},
+		{pattern: "**/mocks", filename: "/src/app/mocks_test/db.go", expMatch: false},
+		{pattern: "./internal/*/gen", filename: abs("internal/db/gen/query.go"), expMatch: true},
+		{pattern: "./internal/*/gen", filename: abs("internal/db/sub/gen/query.go"), expMatch: false},
+		// Regular expressions
+		{pattern: `re:_gen\.go$`, filename: "/src/app/model_gen.go", expMatch: true},
+		{pattern: `re:/testdata/`, filename: "/src/app/testdata/file.go", expMatch: true},
+		{pattern: `re:^/vendor/`, filename: "/src/vendor/file.go", expMatch: false},
+	} {
+		rule, err := errparser.NewPathRule(tc.pattern)
+		if err != nil {
+			t.Fatalf("%s: failed to create the rule: %v", tc.pattern, err)
+		}
+		if match := rule.Match(tc.filename); match != tc.expMatch {
+			t.Errorf("%s: expected match %v for %s, got %v", tc.pattern, tc.expMatch, tc.filename, match)
+		}
+	}
+
+	for _, invalid := range []string{"re:(", "**/[a-"} {
+		if _, err := errparser.NewPathRule(invalid); err == nil {
+			t.Errorf("%s: expected an error", invalid)
+		}
+	}
+}
@@ -7,8 +7,12 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"go/types"
+	"io/fs"
 	"log"
+	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"golang.org/x/tools/go/packages"
@@ -18,25 +22,123 @@ import (
 type Parser struct {
 	pkgs          []*packages.Package
 	parseRetError RetParamParseFunc
-
-	// errsToEdit holds all errors that have to be edited.
-	// index in the first slice corresponds to the package index;
-	// index in the second slice corresponds to the statement order
-	errsToEdit [][]ast.Node
+	parseFile     FileParseFunc
+
+	paths            *pathMatcher
+	includeGenerated bool
+	typeAware        bool
+	concreteErrors   ConcreteErrorPolicy
+	classes          []ErrorClass
+
+	// dirs holds the directives of the currently parsed file
+	dirs fileDirectives
+	// file is the currently parsed file
+	file *ast.File
+	// pkgVars holds the names of the package level variables of the currently parsed package,
+	// used to classify the errors if the type information is not loaded
+	pkgVars map[string]bool
+
+	// sites holds all errors that have to be edited
+	sites []ErrorSite
+	// skipped holds the errors skipped by the RetParamParser, e.g. the already wrapped ones
+	skipped []ErrorSite
+	// srcs caches the content of the files the sites are found in
+	srcs map[string][]byte
+	// declOffsets caches the offsets of the declarations of the identifiers of each file, see declOffset
+	declOffsets map[string]map[int]int
 }
 
 type ParserOptions struct {
 	// RetParamParser parses and possibly modified each node that represents a returned error
 	RetParamParser RetParamParseFunc
+	// FileParser, if given, is called for each parsed file, before its errors are found.
+	// The skipped and ignored files are not parsed.
+	FileParser FileParseFunc
 	// SkipPaths lists all the paths that should not be analyzed.
 	// The output path should be included here.
+	// Each one is a path, a glob pattern or a regular expression, see PathRule.
 	SkipPaths []string
+	// IncludePaths lists the paths that should be analyzed, the same way as SkipPaths.
+	// If given, only the files matching one of them are analyzed, unless skipped.
+	IncludePaths []string
+	// IncludeGenerated analyzes the generated files too. They are recognized by the
+	// `// Code generated ... DO NOT EDIT.` header and skipped by default,
+	// as the changes would be lost on the next generation.
+	IncludeGenerated bool
+	// IncludeVendor analyzes the files within the vendor directories too.
+	// They are skipped by default. The go tool doesn't match them with ./..., so they are loaded
+	// with their own patterns; the vendor directory of the module requires the vendor mode, see go help modules.
+	IncludeVendor bool
+	// TypeAware loads the type information of the packages and uses it to find
+	// the error results, including aliases of error and types implementing it.
+	// Otherwise only the results declared with the bare `error` identifier are found.
+	TypeAware bool
+	// ConcreteErrors decides what happens to the results which implement error,
+	// but are not of the error type, e.g. `*MyError` or an interface embedding error.
+	// Wrapping them would not compile, so they are never wrapped.
+	// Used only if TypeAware is set.
+	ConcreteErrors ConcreteErrorPolicy
+	// Tests includes the test files and the external test packages
+	Tests bool
+	// Classes limits the found errors to the given classes, e.g. only the pass-through errors.
+	// The errors with a pinned number are always included. If empty, all errors are included.
+	Classes []ErrorClass
+	// BuildConfigs lists the build configurations the packages are loaded with.
+	// The results are merged, so that each file is parsed only once, within the first
+	// configuration it belongs to. If empty, the default build context is used.
+	BuildConfigs []BuildConfig
+}
+
+// BuildConfig defines the build context of the loaded packages.
+// The empty fields default to the current build context.
+type BuildConfig struct {
+	GOOS   string
+	GOARCH string
+	// Tags are the build tags, e.g. integration
+	Tags []string
+}
+
+// env returns the environment variables setting the build context
+func (c BuildConfig) env() []string {
+	env := os.Environ()
+	if c.GOOS != "" {
+		env = append(env, "GOOS="+c.GOOS)
+	}
+	if c.GOARCH != "" {
+		env = append(env, "GOARCH="+c.GOARCH)
+	}
+	return env
+}
+
+// buildFlags returns the build flags setting the build tags
+func (c BuildConfig) buildFlags() []string {
+	if len(c.Tags) == 0 {
+		return nil
+	}
+	return []string{"-tags=" + strings.Join(c.Tags, ",")}
+}
+
+func (c BuildConfig) String() string {
+	return fmt.Sprintf("GOOS=%q GOARCH=%q tags=%q", c.GOOS, c.GOARCH, c.Tags)
 }
 
+// ConcreteErrorPolicy defines how to handle the results of types implementing error
+type ConcreteErrorPolicy int
+
+const (
+	// ConcreteErrorSkip silently skips the results of concrete error types
+	ConcreteErrorSkip ConcreteErrorPolicy = iota
+	// ConcreteErrorWarn skips the results of concrete error types and logs each skipped function
+	ConcreteErrorWarn
+)
+
 // RetParamParseFunc is called for each node that represents a returned error.
 // If skip is set to true, the node won't be included in the Parse result.
 type RetParamParseFunc func(pkg *packages.Package, retParam ast.Expr) (out ast.Expr, skip bool)
 
+// FileParseFunc is called for each parsed file
+type FileParseFunc func(pkg *packages.Package, file *ast.File)
+
 func GetDefaultOptions() ParserOptions {
 	return ParserOptions{
 		RetParamParser: func(_ *packages.Package, retParam ast.Expr) (out ast.Expr, skip bool) {
@@ -47,86 +149,238 @@ func GetDefaultOptions() ParserOptions {
 
 // New analyses the package at the given directory and returns a new generator for this package
 func New(dir string, options ParserOptions) (Parser, error) {
-	// Get the absolute paths
-	for i, p := range options.SkipPaths {
-		pAbs, err := filepath.Abs(p)
-		if err != nil {
-			return Parser{}, fmt.Errorf("invalid skip path %s, can't create an absolute path: %w", p, err)
-		}
-		options.SkipPaths[i] = pAbs
+	paths, err := newOptionsPathMatcher(options)
+	if err != nil {
+		return Parser{}, err
+	}
+
+	mode := packages.NeedSyntax | packages.NeedFiles | packages.NeedName
+	if options.TypeAware {
+		mode |= packages.NeedTypes | packages.NeedTypesInfo | packages.NeedImports | packages.NeedDeps
 	}
 
 	// To load all project files
-	cfg := &packages.Config{
-		Mode:  packages.NeedSyntax | packages.NeedFiles | packages.NeedName,
+	cfg := packages.Config{
+		Mode:  mode,
 		Dir:   dir,
-		Tests: false,
+		Tests: options.Tests,
+		// All configurations share the file set to keep the positions unique
+		Fset: token.NewFileSet(),
 		ParseFile: func(fset *token.FileSet, filename string, data []byte) (*ast.File, error) {
-			// Check if the file is within the files to skip
-			for _, p := range options.SkipPaths {
-				if strings.Contains(filename, p) {
+			// The type checker needs all the package files,
+			// the skipped ones are then filtered out when parsing
+			if !options.TypeAware {
+				// Check if the file is within the files to skip
+				if paths.shouldSkip(filename) {
+					return nil, nil
+				}
+				if !options.IncludeGenerated && isGenerated(data) {
+					paths.skipGenerated(filename)
 					return nil, nil
 				}
-			}
 
-			// Check if there are any return or error statements in the file.
-			// If none found -> we can skip processing this file
-			if !bytes.Contains(data, []byte("return")) && !bytes.Contains(data, []byte("error")) {
-				return nil, nil
+				// Check if there are any return or error statements in the file.
+				// If none found -> only the package clause is parsed, there are no errors to find
+				if !bytes.Contains(data, []byte("return")) && !bytes.Contains(data, []byte("error")) {
+					return parser.ParseFile(fset, filename, data, parser.PackageClauseOnly)
+				}
 			}
 
-			const mode = parser.AllErrors | parser.SkipObjectResolution
+			const mode = parser.AllErrors | parser.SkipObjectResolution | parser.ParseComments
 			return parser.ParseFile(fset, filename, data, mode)
 		},
 	}
 
+	buildConfigs := options.BuildConfigs
+	if len(buildConfigs) == 0 {
+		buildConfigs = []BuildConfig{{}}
+	}
+
 	// Load all nested packages within the directory
-	const patterns = "./..."
-	pkgs, err := packages.Load(cfg, patterns)
-	if err != nil {
-		return Parser{}, err
+	patterns := []string{"./..."}
+	if options.IncludeVendor {
+		vendorPatterns, err := findVendorPatterns(dir)
+		if err != nil {
+			return Parser{}, err
+		}
+		patterns = append(patterns, vendorPatterns...)
 	}
 
-	if cnt := packages.PrintErrors(pkgs); cnt > 0 {
-		return Parser{}, fmt.Errorf("failed to load %d packages", cnt)
+	var pkgs []*packages.Package
+	for _, buildConfig := range buildConfigs {
+		cfg.Env = buildConfig.env()
+		cfg.BuildFlags = buildConfig.buildFlags()
+
+		loaded, err := packages.Load(&cfg, patterns...)
+		if err != nil {
+			return Parser{}, fmt.Errorf("build config %s: %w", buildConfig, err)
+		}
+
+		if cnt := packages.PrintErrors(loaded); cnt > 0 {
+			return Parser{}, fmt.Errorf("build config %s: failed to load %d packages", buildConfig, cnt)
+		}
+
+		// The test binaries contain only the generated main files
+		loaded = slices.DeleteFunc(loaded, func(pkg *packages.Package) bool {
+			return strings.HasSuffix(pkg.ID, ".test")
+		})
+		// Keep the order of the configurations, they decide which package a file belongs to
+		slices.SortFunc(loaded, func(a, b *packages.Package) int {
+			return strings.Compare(a.ID, b.ID)
+		})
+		pkgs = append(pkgs, loaded...)
 	}
 
 	if len(pkgs) == 0 {
 		return Parser{}, fmt.Errorf("no packages found in %s", dir)
 	}
 
+	return newParser(pkgs, paths, options), nil
+}
+
+// findVendorPatterns returns the patterns of the packages within the vendor directories of the module,
+// e.g. ./vendor/... The directories ignored by the go tool and the nested modules are not searched.
+func findVendorPatterns(dir string) ([]string, error) {
+	var patterns []string
+	err := filepath.WalkDir(dir, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || filename == dir {
+			return nil
+		}
+		name := d.Name()
+		if name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(filename, "go.mod")); err == nil {
+			return filepath.SkipDir
+		}
+		if name != "vendor" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filename)
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, "./"+filepath.ToSlash(rel)+"/...")
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the vendor directories: %w", err)
+	}
+	return patterns, nil
+}
+
+// NewFromPackages returns a new parser of the already loaded packages, e.g. by an analysis driver.
+// The packages must be loaded with the syntax, and with the type information if TypeAware is set.
+// They are not modified while parsing.
+func NewFromPackages(pkgs []*packages.Package, options ParserOptions) (Parser, error) {
+	paths, err := newOptionsPathMatcher(options)
+	if err != nil {
+		return Parser{}, err
+	}
+	return newParser(pkgs, paths, options), nil
+}
+
+func newParser(pkgs []*packages.Package, paths *pathMatcher, options ParserOptions) Parser {
 	return Parser{
-		pkgs:          pkgs,
-		parseRetError: options.RetParamParser,
-	}, nil
+		pkgs:             pkgs,
+		parseRetError:    options.RetParamParser,
+		parseFile:        options.FileParser,
+		paths:            paths,
+		includeGenerated: options.IncludeGenerated,
+		typeAware:        options.TypeAware,
+		concreteErrors:   options.ConcreteErrors,
+		classes:          options.Classes,
+	}
 }
 
-// Parse returns the error nodes that represent returned error params. They are
-// divided into the packages they belong to.
-func (g *Parser) Parse() (map[*packages.Package][]ast.Node, error) {
-	g.errsToEdit = make([][]ast.Node, len(g.pkgs))
+// newOptionsPathMatcher returns the matcher of the skip and include paths given in the options
+func newOptionsPathMatcher(options ParserOptions) (*pathMatcher, error) {
+	skipPaths := options.SkipPaths
+	if !options.IncludeVendor {
+		skipPaths = slices.Concat(skipPaths, []string{vendorRule})
+	}
+	return newPathMatcher(skipPaths, options.IncludePaths)
+}
+
+// RuleMatches returns the files matched by the skip and include rules
+// together with the matching rule
+func (g *Parser) RuleMatches() []RuleMatch {
+	return g.paths.ruleMatches()
+}
+
+// Skipped returns the sites skipped by the RetParamParser during the last Parse,
+// e.g. the already wrapped errors, in the same order as the parsed sites.
+// Their node is the error expression passed to the RetParamParser.
+// The Classes option doesn't apply to them.
+func (g *Parser) Skipped() []ErrorSite {
+	return g.skipped
+}
 
-	for pkgIdx, pkg := range g.pkgs {
+// Parse returns the sites of the returned errors, ordered by the package import path,
+// the file name and the position within the file.
+func (g *Parser) Parse() ([]ErrorSite, error) {
+	g.sites = nil
+	g.skipped = nil
+	g.srcs = make(map[string][]byte)
+	g.declOffsets = make(map[string]map[int]int)
+
+	// The package variables are collected before any file is dropped
+	pkgVars := make(map[*packages.Package]map[string]bool, len(g.pkgs))
+	if !g.typeAware {
+		for _, pkg := range g.pkgs {
+			pkgVars[pkg] = collectPkgVars(pkg)
+		}
+	}
 
-		g.filterPackageDecls(pkg)
+	// The same file may belong to multiple packages, e.g. to the package
+	// and its test variant or to the same package loaded with a different
+	// build configuration; it's parsed only within the first one.
+	parsedFiles := make(map[string]bool)
+	for _, pkg := range g.pkgs {
+		g.pkgVars = pkgVars[pkg]
 
-		// The remaining declarations are now only function declarations that return an error
 		for _, stxFile := range pkg.Syntax {
 			filename := getFilename(pkg, stxFile.FileStart)
+			if parsedFiles[filename] {
+				continue
+			}
+			parsedFiles[filename] = true
+			if g.skipFile(pkg, stxFile) {
+				continue
+			}
+
+			// The directives are applied before calling the user's RetParamParser
+			g.dirs = parseDirectives(pkg.Fset, stxFile)
+			g.file = stxFile
+			if g.dirs.ignoreFile {
+				continue
+			}
+			if g.parseFile != nil {
+				g.parseFile(pkg, stxFile)
+			}
 
+			// Only the declarations containing a function returning an error are parsed
 			for _, d := range stxFile.Decls {
-				funcDecl, ok := d.(*ast.FuncDecl)
-				if !ok {
-					// It's a bug!
-					return nil, fmt.Errorf("%s: expected a function declaration, found: %T %+v", filename, d, d)
+				if !g.containsErrorFunc(pkg, d) {
+					continue
 				}
-
-				if funcDecl.Body == nil {
-					// Shouldn't happen
-					return nil, fmt.Errorf("%s: function declaration has no body: %s", filename, funcDecl.Name)
+				var err error
+				switch decl := d.(type) {
+				case *ast.FuncDecl:
+					if decl.Body == nil || hasIgnoreDirective(decl.Doc) || g.dirs.ignored(decl) {
+						continue
+					}
+					err = g.parseFunction(pkg, declScope(pkg, decl), decl.Type, decl.Body)
+				case *ast.GenDecl:
+					// Function literals assigned to the package level variables
+					err = g.parseVarFuncLits(pkg, decl)
+				default:
+					// It's a bug!
+					return nil, fmt.Errorf("%s: unexpected declaration, found: %T %+v", filename, d, d)
 				}
-
-				err := g.parseFunction(pkg, pkgIdx, funcDecl.Type, funcDecl.Body)
 				if err != nil {
 					return nil, fmt.Errorf("%s: failed to update function: %w", filename, err)
 				}
@@ -134,57 +388,123 @@ func (g *Parser) Parse() (map[*packages.Package][]ast.Node, error) {
 		}
 	}
 
-	// Convert the errsToEdit to the returned map
-	ret := make(map[*packages.Package][]ast.Node, len(g.pkgs))
-	for i, nodes := range g.errsToEdit {
-		pkg := g.pkgs[i]
-		ret[pkg] = nodes
+	slices.SortStableFunc(g.sites, compareSites)
+	slices.SortStableFunc(g.skipped, compareSites)
+	return g.sites, nil
+}
+
+// parseVarFuncLits parses the function literals assigned to the package level variables,
+// they are named after the variable
+func (g *Parser) parseVarFuncLits(pkg *packages.Package, decl *ast.GenDecl) error {
+	var errs []error
+	for _, spec := range decl.Specs {
+		valueSpec, ok := spec.(*ast.ValueSpec)
+		if !ok || len(valueSpec.Names) == 0 {
+			continue
+		}
+		for i, value := range valueSpec.Values {
+			name := valueSpec.Names[min(i, len(valueSpec.Names)-1)]
+			if err := g.parseFuncLits(pkg, varScope(pkg, name.Name), value); err != nil {
+				errs = append(errs, err)
+			}
+		}
 	}
+	return errors.Join(errs...)
+}
+
+// parseFuncLits parses all function literals found in the node
+func (g *Parser) parseFuncLits(pkg *packages.Package, scope funcScope, node ast.Node) error {
+	inspectErrs := make([]error, 0)
+	ast.Inspect(node, func(n ast.Node) bool {
+		funcLit, ok := n.(*ast.FuncLit)
+		if !ok {
+			return true
+		}
+		// The ignored literals are counted too, to keep the names stable
+		litScope := scope.lit(funcLit)
+		if g.dirs.ignored(funcLit) {
+			return false
+		}
+		if err := g.parseFunction(pkg, litScope, funcLit.Type, funcLit.Body); err != nil {
+			inspectErrs = append(inspectErrs, err)
+		}
+		return false
+	})
 
-	return ret, nil
+	return errors.Join(inspectErrs...)
 }
 
-func (g *Parser) parseFunction(pkg *packages.Package, pkgIdx int, funcType *ast.FuncType, funcBody *ast.BlockStmt) error {
+func (g *Parser) parseFunction(pkg *packages.Package, scope funcScope, funcType *ast.FuncType, funcBody *ast.BlockStmt) error {
 
-	retErrIdx := g.findResultParamIdx(funcType)
-	if retErrIdx == -1 {
-		// Error is not in the returned values
-		return nil
+	retErrIdxs := g.findResultParamIdxs(pkg, funcType)
+	if len(retErrIdxs) == 0 {
+		// Error is not in the returned values,
+		// but the function literals within the body may return it
+		return g.parseFuncLits(pkg, scope, funcBody)
 	}
 	inspectErrs := make([]error, 0)
-	for _, stmt := range funcBody.List {
-		ast.Inspect(stmt, func(n ast.Node) bool {
-			switch node := n.(type) {
-			case *ast.FuncLit:
-				// Parsing an annonymous function
-				if err := g.parseFunction(pkg, pkgIdx, node.Type, node.Body); err != nil {
-					inspectErrs = append(inspectErrs, err)
-					return false
-				}
+	// prevStmts holds the statements preceding each return statement in its block
+	prevStmts := make(map[*ast.ReturnStmt][]ast.Stmt)
+	ast.Inspect(funcBody, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.FuncLit:
+			litScope := scope.lit(node)
+			if g.dirs.ignored(node) {
 				return false
-			case *ast.ReturnStmt:
-				g.parseResultParams(pkg, pkgIdx, node, retErrIdx, funcType.Results.NumFields())
+			}
+			// Parsing an annonymous function
+			if err := g.parseFunction(pkg, litScope, node.Type, node.Body); err != nil {
+				inspectErrs = append(inspectErrs, err)
 				return false
-			default:
-				return true
 			}
-		})
-	}
+			return false
+		case *ast.BlockStmt:
+			collectPrevStmts(prevStmts, node.List)
+			return true
+		case *ast.CaseClause:
+			collectPrevStmts(prevStmts, node.Body)
+			return true
+		case *ast.CommClause:
+			collectPrevStmts(prevStmts, node.Body)
+			return true
+		case *ast.ReturnStmt:
+			g.parseResultParams(pkg, scope, funcType, node, prevStmts[node], retErrIdxs)
+			// The returned values may contain function literals
+			return true
+		case *ast.DeferStmt:
+			g.parseDeferredAssigns(pkg, scope, funcType, node, retErrIdxs)
+			// The deferred function literal is parsed as any other one
+			return true
+		default:
+			return true
+		}
+	})
 
 	return errors.Join(inspectErrs...)
 }
 
-// findResultParamIdx returns -1 if error in not found among returned params
-func (g Parser) findResultParamIdx(funcType *ast.FuncType) int {
-	// Find which ret param is an error
-	retErrIdx := -1
+// findResultParamIdxs returns the indexes of all error results,
+// empty if error in not found among returned params
+func (g Parser) findResultParamIdxs(pkg *packages.Package, funcType *ast.FuncType) []int {
+	if funcType.Results == nil {
+		return nil
+	}
+
+	// Find which ret params are errors
+	var retErrIdxs []int
 	paramCnt := 0
 	for _, res := range funcType.Results.List {
-		// The returned error is of the ast.Ident type
-		resType, ok := res.Type.(*ast.Ident)
-		if ok && resType.Name == "error" {
-			retErrIdx = paramCnt
-			break
+		switch g.resultKind(pkg, res.Type) {
+		case resultError:
+			// All the names share the same type
+			for i := range max(len(res.Names), 1) {
+				retErrIdxs = append(retErrIdxs, paramCnt+i)
+			}
+		case resultConcreteError:
+			if g.concreteErrors == ConcreteErrorWarn {
+				resType := types.TypeString(pkg.TypesInfo.TypeOf(res.Type), types.RelativeTo(pkg.Types))
+				log.Default().Println(makeErrorMsgf(pkg, res, "result of type %s implements error, but can't be wrapped", resType))
+			}
 		}
 
 		// If a function returns multiple times the same type and it's named,
@@ -197,38 +517,420 @@ func (g Parser) findResultParamIdx(funcType *ast.FuncType) int {
 			paramCnt++
 		}
 	}
-	return retErrIdx
+	return retErrIdxs
 }
 
-func (g *Parser) parseResultParams(pkg *packages.Package, pkgIdx int, returnStmt *ast.ReturnStmt, retErrIdx int, retNumFields int) error {
+type resultKind int
+
+const (
+	resultNotError resultKind = iota
+	// resultError is of the error type or its alias, can be wrapped
+	resultError
+	// resultConcreteError implements error, but can't be wrapped
+	resultConcreteError
+)
+
+var errorType = types.Universe.Lookup("error").Type()
+
+// resultKind checks if the result type expression is an error
+func (g Parser) resultKind(pkg *packages.Package, resType ast.Expr) resultKind {
+	if g.typeAware && pkg.TypesInfo != nil {
+		if tp := pkg.TypesInfo.TypeOf(resType); tp != nil {
+			if types.Identical(tp, errorType) {
+				return resultError
+			}
+			if types.Implements(tp, errorType.Underlying().(*types.Interface)) {
+				return resultConcreteError
+			}
+			return resultNotError
+		}
+	}
+
+	// The returned error is of the ast.Ident type
+	ident, ok := resType.(*ast.Ident)
+	if ok && ident.Name == "error" {
+		return resultError
+	}
+	return resultNotError
+}
+
+// collectPrevStmts assigns the preceding statements to each return statement in the list
+func collectPrevStmts(prevStmts map[*ast.ReturnStmt][]ast.Stmt, list []ast.Stmt) {
+	for i := 1; i < len(list); i++ {
+		if ret, ok := list[i].(*ast.ReturnStmt); ok {
+			prevStmts[ret] = list[:i]
+		}
+	}
+}
+
+func (g *Parser) parseResultParams(pkg *packages.Package, scope funcScope, funcType *ast.FuncType, returnStmt *ast.ReturnStmt, prevStmts []ast.Stmt, retErrIdxs []int) error {
+	if g.dirs.ignored(returnStmt) {
+		return nil
+	}
+	retNumFields := funcType.Results.NumFields()
+
+	if len(returnStmt.Results) == 0 && retNumFields > 0 {
+		// Just a return keyword is given with no params,
+		// the named error results are returned
+		return g.parseBareReturn(pkg, scope, funcType, returnStmt, prevStmts, retErrIdxs)
+	}
+
+	if len(returnStmt.Results) == 1 && retNumFields > 1 {
+		// The returned value is a function call returning multiple values
+		return g.parseTupleReturn(pkg, scope, returnStmt, retErrIdxs, retNumFields)
+	}
 
 	if len(returnStmt.Results) != retNumFields {
-		// There are 2 reasons for it:
-		// - just a return keyword is given with no params
-		// - the returned value is a function call
-		// We will ignore both of these cases.
 		log.Default().Println(makeErrorMsgf(pkg, returnStmt, "unexpected number of returned values: %v/%v", len(returnStmt.Results), retNumFields))
 		return nil
 	}
 
-	retParam := returnStmt.Results[retErrIdx]
-	retIdent, ok := retParam.(*ast.Ident)
-	if ok {
-		if retIdent.Name == "nil" {
+	// Each returned error is handled separately,
+	// the pinned codes are assigned to them in order
+	codes := g.dirs.codes(returnStmt)
+	for _, retErrIdx := range retErrIdxs {
+		retParam := returnStmt.Results[retErrIdx]
+		retIdent, ok := retParam.(*ast.Ident)
+		if ok {
+			if retIdent.Name == "nil" {
+				// Ignore
+				continue
+			}
+		}
+
+		var code []int
+		if len(codes) > 0 {
+			code, codes = codes[:1], codes[1:]
+		}
+		class := g.classify(pkg, retParam)
+
+		// Let the user parse and edit the returned param node and notify if it should
+		// be added to the output nodes.
+		// The pinned node is kept, even if skipped, to update its number.
+		retParam, skip := g.parseRetError(pkg, retParam)
+		site := ErrorSite{
+			Kind:       SiteReturn,
+			Node:       retParam,
+			Class:      class,
+			ResultIdxs: []int{retErrIdx},
+			NumResults: retNumFields,
+			Codes:      code,
+		}
+		if skip && len(code) == 0 {
+			g.addSkipped(pkg, scope, site)
+			continue
+		}
+
+		// Add to the found errors
+		g.addSite(pkg, scope, site)
+	}
+	return nil
+}
+
+func (g *Parser) parseBareReturn(pkg *packages.Package, scope funcScope, funcType *ast.FuncType, returnStmt *ast.ReturnStmt, prevStmts []ast.Stmt, retErrIdxs []int) error {
+	// Each error result may be assigned right before returning,
+	// so look for the assignments only in the preceding statements
+	prevStmts = prevStmts[max(len(prevStmts)-len(retErrIdxs), 0):]
+
+	site := ErrorSite{
+		Kind:       SiteBareReturn,
+		Node:       returnStmt,
+		NumResults: funcType.Results.NumFields(),
+		Codes:      g.dirs.codes(returnStmt),
+	}
+	for _, retErrIdx := range retErrIdxs {
+		errName := resultName(funcType, retErrIdx)
+		if errName == nil || errName.Name == "_" {
+			log.Default().Println(makeErrorMsgf(pkg, returnStmt, "bare return with no named error result"))
+			continue
+		}
+
+		// The value assigned to the error right before returning is checked by the user,
+		// for example to find out if it's already wrapped.
+		// Otherwise the named result itself is checked.
+		var retParam ast.Expr = errName
+		for _, stmt := range prevStmts {
+			if assigned := assignedValue(stmt, errName.Name); assigned != nil {
+				retParam = assigned
+			}
+		}
+
+		if retIdent, ok := retParam.(*ast.Ident); ok && retIdent.Name == "nil" {
 			// Ignore
+			continue
+		}
+
+		if _, skip := g.parseRetError(pkg, retParam); skip {
+			g.addSkipped(pkg, scope, ErrorSite{
+				Kind:       SiteBareReturn,
+				Node:       retParam,
+				Class:      g.classify(pkg, retParam),
+				ResultIdxs: []int{retErrIdx},
+				NumResults: site.NumResults,
+				ErrNames:   []*ast.Ident{errName},
+			})
+			continue
+		}
+		if len(site.ResultIdxs) == 0 {
+			site.Class = g.classify(pkg, retParam)
+		}
+		site.ResultIdxs = append(site.ResultIdxs, retErrIdx)
+		site.ErrNames = append(site.ErrNames, errName)
+	}
+
+	if len(site.ErrNames) > 0 {
+		// Add to the found errors
+		g.addSite(pkg, scope, site)
+	}
+	return nil
+}
+
+func (g *Parser) parseTupleReturn(pkg *packages.Package, scope funcScope, returnStmt *ast.ReturnStmt, retErrIdxs []int, retNumFields int) error {
+	// Only a function call can return multiple values
+	call, ok := ast.Unparen(returnStmt.Results[0]).(*ast.CallExpr)
+	if !ok {
+		log.Default().Println(makeErrorMsgf(pkg, returnStmt, "unexpected returned value, expected a function call"))
+		return nil
+	}
+
+	if g.typeAware && pkg.TypesInfo != nil {
+		// Make sure that the call returns the same number of values
+		tuple, ok := pkg.TypesInfo.TypeOf(call).(*types.Tuple)
+		if !ok || tuple.Len() != retNumFields {
+			log.Default().Println(makeErrorMsgf(pkg, returnStmt, "unexpected number of values returned by the call, expected %v", retNumFields))
 			return nil
 		}
 	}
 
-	// Let the user parse and edit the returned param node and notify if it should
-	// be added to the output nodes
-	retParam, skip := g.parseRetError(pkg, retParam)
-	if skip {
+	site := ErrorSite{
+		Kind:       SiteTupleReturn,
+		Node:       returnStmt,
+		Class:      ClassCall,
+		ResultIdxs: retErrIdxs,
+		NumResults: retNumFields,
+		Call:       call,
+		Codes:      g.dirs.codes(returnStmt),
+	}
+	if _, skip := g.parseRetError(pkg, call); skip {
+		site.Node = call
+		g.addSkipped(pkg, scope, site)
 		return nil
 	}
 
 	// Add to the found errors
-	g.errsToEdit[pkgIdx] = append(g.errsToEdit[pkgIdx], retParam)
+	g.addSite(pkg, scope, site)
+	return nil
+}
+
+// parseDeferredAssigns finds the values assigned to the named error results
+// within the deferred function literal, e.g.
+//
+//	defer func() {
+//		if cerr := f.Close(); cerr != nil && err == nil {
+//			err = cerr
+//		}
+//	}()
+//
+// Such errors are returned without passing through any return statement.
+func (g *Parser) parseDeferredAssigns(pkg *packages.Package, scope funcScope, funcType *ast.FuncType, deferStmt *ast.DeferStmt, retErrIdxs []int) {
+	funcLit, ok := deferStmt.Call.Fun.(*ast.FuncLit)
+	if !ok {
+		return
+	}
+	for _, retErrIdx := range retErrIdxs {
+		errName := resultName(funcType, retErrIdx)
+		if errName == nil || errName.Name == "_" {
+			continue
+		}
+		site := ErrorSite{
+			Kind:       SiteDeferredAssign,
+			ResultIdxs: []int{retErrIdx},
+			NumResults: funcType.Results.NumFields(),
+			ErrNames:   []*ast.Ident{errName},
+		}
+		g.parseDeferredAssign(pkg, scope, funcLit, site)
+	}
+}
+
+// parseDeferredAssign finds the values assigned to the named error result of the site within the function literal
+func (g *Parser) parseDeferredAssign(pkg *packages.Package, scope funcScope, funcLit *ast.FuncLit, site ErrorSite) {
+	errName := site.ErrNames[0]
+	ast.Inspect(funcLit.Body, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.FuncLit:
+			// The nested function literals are not deferred
+			return false
+		case *ast.AssignStmt:
+			if node.Tok != token.ASSIGN || g.dirs.ignored(node) {
+				return true
+			}
+			for i, lhs := range node.Lhs {
+				ident, ok := lhs.(*ast.Ident)
+				if !ok || !g.isResult(pkg, ident, errName) {
+					continue
+				}
+				if len(node.Lhs) != len(node.Rhs) {
+					log.Default().Println(makeErrorMsgf(pkg, node, "can't wrap %s assigned from a multi-value expression", ident.Name))
+					continue
+				}
+
+				assigned := node.Rhs[i]
+				if assignedIdent, ok := assigned.(*ast.Ident); ok &&
+					(assignedIdent.Name == "nil" || assignedIdent.Name == errName.Name) {
+					// Ignore
+					continue
+				}
+
+				// The pinned node is kept, even if skipped, to update its number
+				codes := g.dirs.codes(node)
+				site.Node = assigned
+				site.Class = g.classify(pkg, assigned)
+				site.Codes = codes
+				if _, skip := g.parseRetError(pkg, assigned); skip && len(codes) == 0 {
+					g.addSkipped(pkg, scope, site)
+					continue
+				}
+
+				// Add to the found errors
+				g.addSite(pkg, scope, site)
+			}
+			return true
+		default:
+			return true
+		}
+	})
+}
+
+// addSite completes the site with its position, source and function, and adds it to the found errors
+func (g *Parser) addSite(pkg *packages.Package, scope funcScope, site ErrorSite) {
+	if len(g.classes) > 0 && !slices.Contains(g.classes, site.Class) && len(site.Codes) == 0 {
+		return
+	}
+	g.sites = append(g.sites, g.completeSite(pkg, scope, site))
+}
+
+// addSkipped completes the site skipped by the RetParamParser and adds it to the skipped errors
+func (g *Parser) addSkipped(pkg *packages.Package, scope funcScope, site ErrorSite) {
+	g.skipped = append(g.skipped, g.completeSite(pkg, scope, site))
+}
+
+// completeSite sets the position, source and function of the site
+func (g *Parser) completeSite(pkg *packages.Package, scope funcScope, site ErrorSite) ErrorSite {
+	site.Pkg = pkg
+	site.Func = scope.node
+	site.FuncName = scope.name
+	site.Recv = scope.recv
+	// The line directives are ignored, the sites point to the parsed files
+	site.Pos = pkg.Fset.PositionFor(site.Node.Pos(), false)
+	site.End = pkg.Fset.PositionFor(site.Node.End(), false)
+	site.Source = g.source(site.Pos, site.End)
+	return site
+}
+
+// source returns the source text between the positions
+func (g *Parser) source(start, end token.Position) string {
+	src := g.readSource(start.Filename)
+	if end.Offset > len(src) || start.Offset > end.Offset {
+		return ""
+	}
+	return string(src[start.Offset:end.Offset])
+}
+
+// readSource returns the content of the file, it's cached
+func (g *Parser) readSource(filename string) []byte {
+	src, ok := g.srcs[filename]
+	if !ok {
+		var err error
+		src, err = os.ReadFile(filename)
+		if err != nil {
+			log.Default().Printf("%s - failed to read the source: %v", filename, err)
+		}
+		g.srcs[filename] = src
+	}
+	return src
+}
+
+// isResult reports whether the identifier refers to the named result.
+// Without the type information the identifier is resolved within the syntax of its file, see declOffset.
+func (g *Parser) isResult(pkg *packages.Package, ident *ast.Ident, resName *ast.Ident) bool {
+	if ident.Name != resName.Name {
+		return false
+	}
+	if g.typeAware && pkg.TypesInfo != nil {
+		obj := pkg.TypesInfo.Uses[ident]
+		return obj != nil && obj == pkg.TypesInfo.Defs[resName]
+	}
+	offset, ok := g.declOffset(pkg, ident)
+	if !ok {
+		// Not resolved, only the names are compared
+		return true
+	}
+	return offset == pkg.Fset.PositionFor(resName.Pos(), false).Offset
+}
+
+// declOffset returns the offset of the declaration the identifier refers to within its file.
+// The files are parsed skipping the object resolution, so the file is parsed again to resolve its identifiers.
+func (g *Parser) declOffset(pkg *packages.Package, ident *ast.Ident) (int, bool) {
+	pos := pkg.Fset.PositionFor(ident.Pos(), false)
+	offsets, ok := g.declOffsets[pos.Filename]
+	if !ok {
+		fset := token.NewFileSet()
+		stxFile, err := parser.ParseFile(fset, pos.Filename, g.readSource(pos.Filename), 0)
+		if err == nil {
+			offsets = make(map[int]int)
+			ast.Inspect(stxFile, func(n ast.Node) bool {
+				if ident, ok := n.(*ast.Ident); ok && ident.Obj != nil && ident.Obj.Pos().IsValid() {
+					offsets[fset.Position(ident.Pos()).Offset] = fset.Position(ident.Obj.Pos()).Offset
+				}
+				return true
+			})
+		}
+		g.declOffsets[pos.Filename] = offsets
+	}
+	if offsets == nil {
+		return 0, false
+	}
+	offset, ok := offsets[pos.Offset]
+	return offset, ok
+}
+
+// resultName returns the name of the result at the given index
+// or nil if the results are not named
+func resultName(funcType *ast.FuncType, idx int) *ast.Ident {
+	paramCnt := 0
+	for _, res := range funcType.Results.List {
+		if len(res.Names) == 0 {
+			return nil
+		}
+		for _, name := range res.Names {
+			if paramCnt == idx {
+				return name
+			}
+			paramCnt++
+		}
+	}
+	return nil
+}
+
+// assignedValue returns the value assigned to the variable by the statement.
+// Both `name = value` and `if name != nil { name = value }` are recognized.
+func assignedValue(stmt ast.Stmt, name string) ast.Expr {
+	if ifStmt, ok := stmt.(*ast.IfStmt); ok {
+		if ifStmt.Init != nil || ifStmt.Else != nil || len(ifStmt.Body.List) != 1 {
+			return nil
+		}
+		stmt = ifStmt.Body.List[0]
+	}
+
+	assign, ok := stmt.(*ast.AssignStmt)
+	if !ok || assign.Tok != token.ASSIGN || len(assign.Lhs) != len(assign.Rhs) {
+		return nil
+	}
+	for i, lhs := range assign.Lhs {
+		if ident, ok := lhs.(*ast.Ident); ok && ident.Name == name {
+			return assign.Rhs[i]
+		}
+	}
 	return nil
 }
 
@@ -258,56 +960,49 @@ func makeErrorMsgf(pkg *packages.Package, node ast.Node, message string, args ..
 	return msg
 }
 
-func (g *Parser) filterPackageDecls(pkg *packages.Package) error {
-	stxIdx := 0
-	for _, stxFile := range pkg.Syntax {
-		filename := getFilename(pkg, stxFile.FileStart)
-
-		// Filter functions that return an error
-		j := 0
-		for _, decl := range stxFile.Decls {
-			fnDecl, ok := decl.(*ast.FuncDecl)
-			if !ok ||
-				fnDecl.Type.Results == nil ||
-				len(fnDecl.Type.Results.List) == 0 {
-				continue
-			}
-
-			rets := fnDecl.Type.Results.List
-			for _, ret := range rets {
-				// Errors will always implement the Stringer interface
-				tp, ok := ret.Type.(fmt.Stringer)
-				if !ok {
-					continue
-				}
+// skipFile reports whether the file matches the skip rules or is generated
+func (g *Parser) skipFile(pkg *packages.Package, stxFile *ast.File) bool {
+	filename := getFilename(pkg, stxFile.FileStart)
+	if g.paths.shouldSkip(filename) {
+		return true
+	}
+	if !g.includeGenerated && ast.IsGenerated(stxFile) {
+		g.paths.skipGenerated(filename)
+		return true
+	}
+	return false
+}
 
-				if tp.String() == "error" {
-					// Found a function that returns an error,
-					// keep it in the declarations list
-					stxFile.Decls[j] = decl
-					j++
-				}
-			}
+// containsErrorFunc reports whether the declaration is or contains
+// a function returning an error
+func (g Parser) containsErrorFunc(pkg *packages.Package, decl ast.Decl) bool {
+	found := false
+	ast.Inspect(decl, func(n ast.Node) bool {
+		if found {
+			return false
+		}
+		switch node := n.(type) {
+		case *ast.FuncDecl:
+			found = g.hasErrorResult(pkg, node.Type)
+		case *ast.FuncLit:
+			found = g.hasErrorResult(pkg, node.Type)
 		}
-		stxFile.Decls = stxFile.Decls[:j]
+		return !found
+	})
+	return found
+}
 
-		shouldKeep := len(stxFile.Decls) > 0
-		if !shouldKeep {
-			// Remove from token fileset
-			tokenFile := pkg.Fset.File(stxFile.FileStart)
-			if tokenFile == nil {
-				return errors.New(makeErrorMsgf(pkg, stxFile, "%s: failed to get token file", filename))
-			}
-			pkg.Fset.RemoveFile(tokenFile)
-		} else {
-			// Add to the syntax files list
-			pkg.Syntax[stxIdx] = stxFile
-			stxIdx++
+// hasErrorResult reports whether any of the function results implements error
+func (g Parser) hasErrorResult(pkg *packages.Package, funcType *ast.FuncType) bool {
+	if funcType.Results == nil {
+		return false
+	}
+	for _, res := range funcType.Results.List {
+		if g.resultKind(pkg, res.Type) != resultNotError {
+			return true
 		}
 	}
-	pkg.Syntax = pkg.Syntax[:stxIdx]
-
-	return nil
+	return false
 }
 
 func getFilename(pkg *packages.Package, position token.Pos) string {
@@ -4,8 +4,9 @@ import (
 	"go/ast"
 	"io"
 	"log"
-	"os"
 	"path"
+	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/anjankow/errnumgen/pkg/errparser"
@@ -25,24 +26,44 @@ func TestParserReturnsOnlyErrorNodes(t *testing.T) {
 		t.Fatalf("failed to parse: %v", err)
 	}
 
-	if len(parsed) > 1 {
-		t.Fatalf("expected one parsed package, got: %d", len(parsed))
-	}
-
 	// bytealg.go returns no errors
-	// filepathlite.go returns one error
-	var errNode ast.Node
-	for _, nodes := range parsed {
-		if len(nodes) != 1 {
-			t.Fatalf("invalid number of nodes, expected 1, got: %d", len(nodes))
-		}
-		errNode = nodes[0]
+	// filepathlite.go returns one error and forwards the results of one call
+	if len(parsed) != 2 {
+		t.Fatalf("invalid number of sites, expected 2, got: %d", len(parsed))
 	}
+	errSite, callSite := parsed[0], parsed[1]
 
 	// The node should be "errInvalidPath"
-	errIdent, ok := errNode.(*ast.Ident)
+	errIdent, ok := errSite.Node.(*ast.Ident)
 	if !ok || errIdent.Name != "errInvalidPath" {
-		t.Errorf("invalid node found, expected %q, found %q", "errInvalidPath", errNode)
+		t.Errorf("invalid node found, expected %q, found %q", "errInvalidPath", errSite.Source)
+	}
+	if errSite.Kind != errparser.SiteReturn || !slices.Equal(errSite.ResultIdxs, []int{1}) {
+		t.Errorf("invalid site, expected a return of the result 1, found a %s of the results %v", errSite.Kind, errSite.ResultIdxs)
+	}
+	if errSite.Pos.Line != 169 || errSite.Pos.Column != 14 || path.Base(errSite.Pos.Filename) != "filepathlite.go" {
+		t.Errorf("invalid position, expected filepathlite.go:169:14, found %s", errSite.Pos)
+	}
+	if errSite.FuncName != "github.com/anjankow/errnumgen/pkg/errparser/testdata/TestParserReturnsOnlyErrorNodes.Localize" {
+		t.Errorf("invalid function name: %s", errSite.FuncName)
+	}
+	if fn, ok := errSite.Func.(*ast.FuncDecl); !ok || fn.Name.Name != "Localize" {
+		t.Errorf("invalid function found, expected %q, found %T", "Localize", errSite.Func)
+	}
+
+	// The node should be "return localize(path)"
+	if callSite.Kind != errparser.SiteTupleReturn {
+		t.Fatalf("invalid site found, expected a tuple return, found %s", callSite.Kind)
+	}
+	if callSite.Source != "return localize(path)" {
+		t.Errorf("invalid source, expected %q, found %q", "return localize(path)", callSite.Source)
+	}
+	if fn, ok := callSite.Call.Fun.(*ast.Ident); !ok || fn.Name != "localize" {
+		t.Errorf("invalid call found, expected %q, found %v", "localize", callSite.Call.Fun)
+	}
+	if callSite.NumResults != 2 || !slices.Equal(callSite.ResultIdxs, []int{1}) {
+		t.Errorf("invalid tuple return, expected 2 results with the error at 1, found %d results with the errors at %v",
+			callSite.NumResults, callSite.ResultIdxs)
 	}
 }
 
@@ -59,36 +80,13 @@ func TestParserFindsAllErrorNodes(t *testing.T) {
 		t.Fatalf("failed to parse: %v", err)
 	}
 
-	if len(parsed) > 1 {
-		t.Fatalf("expected one parsed package, got: %d", len(parsed))
-	}
-
-	var pkg *packages.Package
-	var errNodes []ast.Node
-	for p, nodes := range parsed {
-		pkg = p
-		errNodes = nodes
-	}
 	expNodesLen := 22
-	if len(errNodes) != expNodesLen {
-		t.Fatalf("invalid number of found error nodes, expected %d, found %d", expNodesLen, len(errNodes))
-	}
-
-	// Read the test file content
-	content, err := os.ReadFile(path.Join("./testdata/", t.Name(), "proto.go"))
-	if err != nil {
-		t.Fatalf("failed to read the test file: %v", err)
+	if len(parsed) != expNodesLen {
+		t.Fatalf("invalid number of found error nodes, expected %d, found %d", expNodesLen, len(parsed))
 	}
 
 	// Now read the found nodes
-	errNodesContent := make([]string, 0, len(errNodes))
-	for _, n := range errNodes {
-		fposStart := pkg.Fset.Position(n.Pos())
-		fposEnd := pkg.Fset.Position(n.End())
-		errNodesContent = append(errNodesContent,
-			string(content[fposStart.Offset:fposEnd.Offset]))
-
-	}
+	errNodesContent := sources(parsed)
 
 	// Assert that the following nodes are present in the list:
 	expNodesSet := []string{
@@ -130,3 +128,432 @@ func TestParserFindsAllErrorNodes(t *testing.T) {
 		}
 	}
 }
+
+func TestParserTypeAwareFindsErrorResults(t *testing.T) {
+
+	dir := path.Join("./testdata/", t.Name())
+	for _, tc := range []struct {
+		typeAware bool
+		expNodes  []string
+	}{
+		{
+			typeAware: false,
+			expNodes:  []string{`errors.New("plain")`, `&MyError{}`},
+		},
+		{
+			// The alias is recognized, the concrete error types are skipped
+			typeAware: true,
+			expNodes:  []string{`errors.New("plain")`, `errors.New("aliased")`, `&MyError{}`},
+		},
+	} {
+		opts := errparser.GetDefaultOptions()
+		opts.TypeAware = tc.typeAware
+		opts.ConcreteErrors = errparser.ConcreteErrorWarn
+		p, err := errparser.New(dir, opts)
+		if err != nil {
+			t.Fatalf("failed to initialize a new parser: %v", err)
+		}
+		parsed, err := p.Parse()
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+
+		found := sources(parsed)
+
+		if !slices.Equal(found, tc.expNodes) {
+			t.Errorf("type aware: %v, expected nodes %q, found %q", tc.typeAware, tc.expNodes, found)
+		}
+	}
+}
+
+func TestParserFindsFuncLitErrors(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	dir := path.Join("./testdata/", t.Name())
+	p, err := errparser.New(dir, errparser.GetDefaultOptions())
+	if err != nil {
+		t.Fatalf("failed to initialize a new parser: %v", err)
+	}
+	parsed, err := p.Parse()
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	found := sources(parsed)
+
+	expNodes := []string{
+		`errors.New("handler: " + name)`,
+		`errFirst`,
+		`errors.New("in closure")`,
+		`errors.New("checker")`,
+		`errors.New("nested")`,
+		`err`,
+	}
+	if !slices.Equal(found, expNodes) {
+		t.Errorf("expected nodes %q, found %q", expNodes, found)
+	}
+	// The function literals are named after their enclosing function or variable
+	const pkgPath = "github.com/anjankow/errnumgen/pkg/errparser/testdata/TestParserFindsFuncLitErrors"
+	expFuncNames := []string{
+		pkgPath + ".handler.func1",
+		pkgPath + ".handlers.func1",
+		pkgPath + ".run.func1",
+		pkgPath + ".newChecker.func1",
+		pkgPath + ".nested.func1",
+		pkgPath + ".nested",
+	}
+	var funcNames []string
+	for _, site := range parsed {
+		funcNames = append(funcNames, site.FuncName)
+	}
+	if !slices.Equal(funcNames, expFuncNames) {
+		t.Errorf("expected function names %q, found %q", expFuncNames, funcNames)
+	}
+	// The same names are found from the nodes
+	for _, site := range parsed {
+		if name := errparser.FuncName(site.Pkg, site.Node); name != site.FuncName {
+			t.Errorf("expected function name %s of %s, found %s", site.FuncName, site.Source, name)
+		}
+	}
+}
+
+func TestParserFindsDeferredAssignments(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	dir := path.Join("./testdata/", t.Name())
+	for _, typeAware := range []bool{false, true} {
+		opts := errparser.GetDefaultOptions()
+		opts.TypeAware = typeAware
+		p, err := errparser.New(dir, opts)
+		if err != nil {
+			t.Fatalf("failed to initialize a new parser: %v", err)
+		}
+		parsed, err := p.Parse()
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+
+		found := sources(parsed)
+
+		expNodes := []string{
+			`err`,
+			`cerr`,
+			`errors.Join(err, os.Remove(name))`,
+			`return`,
+		}
+		if !slices.Equal(found, expNodes) {
+			t.Errorf("type aware: %v, expected nodes %q, found %q", typeAware, expNodes, found)
+		}
+	}
+}
+
+func TestParserClassifiesErrors(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	dir := path.Join("./testdata/", t.Name())
+	expClasses := map[string]errparser.ErrorClass{
+		`errors.New("empty name")`:             errparser.ClassFresh,
+		`io.EOF`:                               errparser.ClassSentinel,
+		`errNotFound`:                          errparser.ClassSentinel,
+		`fmt.Errorf("invalid name %q", name)`:  errparser.ClassFresh,
+		`&MyError{}`:                           errparser.ClassFresh,
+		`s.err`:                                errparser.ClassPassThrough,
+		`fmt.Errorf("load %s: %w", name, err)`: errparser.ClassWrapping,
+		`err`:                                  errparser.ClassPassThrough,
+		`validate(v)`:                          errparser.ClassCall,
+	}
+
+	for _, typeAware := range []bool{false, true} {
+		opts := errparser.GetDefaultOptions()
+		opts.TypeAware = typeAware
+		p, err := errparser.New(dir, opts)
+		if err != nil {
+			t.Fatalf("failed to initialize a new parser: %v", err)
+		}
+		parsed, err := p.Parse()
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+
+		if len(parsed) != len(expClasses) {
+			t.Errorf("type aware: %v, expected %d sites, found %q", typeAware, len(expClasses), sources(parsed))
+		}
+		for _, site := range parsed {
+			if expClass, ok := expClasses[site.Source]; !ok || site.Class != expClass {
+				t.Errorf("type aware: %v, %s: expected class %s, found %s", typeAware, site.Source, expClass, site.Class)
+			}
+		}
+	}
+
+	// Only the sites of the given classes are found
+	opts := errparser.GetDefaultOptions()
+	opts.Classes = []errparser.ErrorClass{errparser.ClassPassThrough}
+	p, err := errparser.New(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to initialize a new parser: %v", err)
+	}
+	parsed, err := p.Parse()
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	expNodes := []string{`s.err`, `err`}
+	if found := sources(parsed); !slices.Equal(found, expNodes) {
+		t.Errorf("expected nodes %q, found %q", expNodes, found)
+	}
+
+	// The sites skipped by the RetParamParser are reported separately, regardless of the classes
+	opts.RetParamParser = func(_ *packages.Package, retParam ast.Expr) (ast.Expr, bool) {
+		_, skip := retParam.(*ast.Ident)
+		return retParam, skip
+	}
+	p, err = errparser.New(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to initialize a new parser: %v", err)
+	}
+	parsed, err = p.Parse()
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	expNodes = []string{`s.err`}
+	if found := sources(parsed); !slices.Equal(found, expNodes) {
+		t.Errorf("expected nodes %q, found %q", expNodes, found)
+	}
+	expSkipped := []string{`errNotFound`, `err`}
+	if found := sources(p.Skipped()); !slices.Equal(found, expSkipped) {
+		t.Errorf("expected skipped nodes %q, found %q", expSkipped, found)
+	}
+}
+
+func TestParserIncludesTestFiles(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	dir := path.Join("./testdata/", t.Name())
+	for _, tc := range []struct {
+		tests    bool
+		expNodes []string
+	}{
+		{
+			tests:    false,
+			expNodes: []string{`errors.New("lib: " + name)`},
+		},
+		{
+			// Each file is parsed once, even if it belongs to the test variant too
+			tests: true,
+			expNodes: []string{
+				`errors.New("lib: " + name)`,
+				`errors.New("internal test")`,
+				`errors.New("external test")`,
+			},
+		},
+	} {
+		opts := errparser.GetDefaultOptions()
+		opts.Tests = tc.tests
+		p, err := errparser.New(dir, opts)
+		if err != nil {
+			t.Fatalf("failed to initialize a new parser: %v", err)
+		}
+		parsed, err := p.Parse()
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+
+		found := sources(parsed)
+
+		slices.Sort(found)
+		slices.Sort(tc.expNodes)
+		if !slices.Equal(found, tc.expNodes) {
+			t.Errorf("tests: %v, expected nodes %q, found %q", tc.tests, tc.expNodes, found)
+		}
+	}
+}
+
+func TestParserLoadsBuildConfigs(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	opts := errparser.GetDefaultOptions()
+	opts.BuildConfigs = []errparser.BuildConfig{
+		{GOOS: "linux", GOARCH: "amd64"},
+		{GOOS: "windows", GOARCH: "amd64"},
+		{GOOS: "linux", GOARCH: "amd64", Tags: []string{"integration"}},
+	}
+	p, err := errparser.New(path.Join("./testdata/", t.Name()), opts)
+	if err != nil {
+		t.Fatalf("failed to initialize a new parser: %v", err)
+	}
+	parsed, err := p.Parse()
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	found := sources(parsed)
+
+	// Each file is parsed once, within the first configuration it belongs to
+	expNodes := []string{
+		`errors.New("common")`,
+		`errors.New("integration")`,
+		`errors.New("linux")`,
+		`errors.New("windows")`,
+	}
+	slices.Sort(found)
+	if !slices.Equal(found, expNodes) {
+		t.Errorf("expected nodes %q, found %q", expNodes, found)
+	}
+}
+
+func TestParserSkipsGeneratedFiles(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	dir := path.Join("./testdata/", t.Name())
+	for _, tc := range []struct {
+		typeAware        bool
+		includeGenerated bool
+		expNodes         []string
+	}{
+		{
+			expNodes: []string{`errors.New("app")`},
+		},
+		{
+			typeAware: true,
+			expNodes:  []string{`errors.New("app")`},
+		},
+		{
+			includeGenerated: true,
+			expNodes:         []string{`errors.New("app")`, `errors.New("generated")`},
+		},
+	} {
+		opts := errparser.GetDefaultOptions()
+		opts.TypeAware = tc.typeAware
+		opts.IncludeGenerated = tc.includeGenerated
+		p, err := errparser.New(dir, opts)
+		if err != nil {
+			t.Fatalf("failed to initialize a new parser: %v", err)
+		}
+		parsed, err := p.Parse()
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+
+		found := sources(parsed)
+
+		slices.Sort(found)
+		if !slices.Equal(found, tc.expNodes) {
+			t.Errorf("type aware: %v, include generated: %v, expected nodes %q, found %q",
+				tc.typeAware, tc.includeGenerated, tc.expNodes, found)
+		}
+	}
+}
+
+func TestParserSkipsVendor(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	dir := path.Join("./testdata/", t.Name())
+	for _, tc := range []struct {
+		typeAware     bool
+		includeVendor bool
+		expNodes      []string
+	}{
+		{
+			expNodes: []string{`errors.New("app")`},
+		},
+		{
+			typeAware: true,
+			expNodes:  []string{`errors.New("app")`},
+		},
+		{
+			includeVendor: true,
+			expNodes:      []string{`errors.New("app")`, `errors.New("vendored")`},
+		},
+		{
+			typeAware:     true,
+			includeVendor: true,
+			expNodes:      []string{`errors.New("app")`, `errors.New("vendored")`},
+		},
+	} {
+		opts := errparser.GetDefaultOptions()
+		opts.TypeAware = tc.typeAware
+		opts.IncludeVendor = tc.includeVendor
+		p, err := errparser.New(dir, opts)
+		if err != nil {
+			t.Fatalf("failed to initialize a new parser: %v", err)
+		}
+		parsed, err := p.Parse()
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+
+		found := sources(parsed)
+
+		slices.Sort(found)
+		if !slices.Equal(found, tc.expNodes) {
+			t.Errorf("type aware: %v, include vendor: %v, expected nodes %q, found %q",
+				tc.typeAware, tc.includeVendor, tc.expNodes, found)
+		}
+	}
+}
+
+func TestParserIncludesPaths(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	dir := path.Join("./testdata/", t.Name())
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatalf("failed to get the absolute path: %v", err)
+	}
+	apiRule := filepath.Join(dir, "api")
+	cacheRule := "**/cache.go"
+	legacyRule := filepath.Join(dir, "api", "legacy.go")
+
+	for _, typeAware := range []bool{false, true} {
+		opts := errparser.GetDefaultOptions()
+		opts.TypeAware = typeAware
+		opts.IncludePaths = []string{apiRule, cacheRule}
+		// The skip rules take precedence
+		opts.SkipPaths = []string{legacyRule}
+		var parsedFiles []string
+		opts.FileParser = func(pkg *packages.Package, file *ast.File) {
+			parsedFiles = append(parsedFiles, pkg.Fset.Position(file.FileStart).Filename)
+		}
+		p, err := errparser.New(dir, opts)
+		if err != nil {
+			t.Fatalf("failed to initialize a new parser: %v", err)
+		}
+		parsed, err := p.Parse()
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+
+		// Only the included files are parsed
+		found := sources(parsed)
+		slices.Sort(found)
+		expNodes := []string{`errors.New("api")`, `errors.New("cache")`}
+		if !slices.Equal(found, expNodes) {
+			t.Errorf("type aware: %v, expected nodes %q, found %q", typeAware, expNodes, found)
+		}
+
+		// Each file is reported with the rule deciding about it
+		expMatches := []errparser.RuleMatch{
+			{Filename: filepath.Join(absDir, "api", "api.go"), Rule: apiRule},
+			{Filename: filepath.Join(absDir, "api", "legacy.go"), Rule: legacyRule, Skipped: true},
+			{Filename: filepath.Join(absDir, "store", "cache.go"), Rule: cacheRule},
+			// No include rule matched
+			{Filename: filepath.Join(absDir, "store", "store.go"), Skipped: true},
+		}
+		if matches := p.RuleMatches(); !slices.Equal(matches, expMatches) {
+			t.Errorf("type aware: %v, expected rule matches %+v, found %+v", typeAware, expMatches, matches)
+		}
+		slices.Sort(parsedFiles)
+		expFiles := []string{filepath.Join(absDir, "api", "api.go"), filepath.Join(absDir, "store", "cache.go")}
+		if !slices.Equal(parsedFiles, expFiles) {
+			t.Errorf("type aware: %v, expected parsed files %q, found %q", typeAware, expFiles, parsedFiles)
+		}
+	}
+}
+
+// sources returns the source text of the sites
+func sources(sites []errparser.ErrorSite) []string {
+	found := make([]string, 0, len(sites))
+	for _, site := range sites {
+		found = append(found, site.Source)
+	}
+	return found
+}
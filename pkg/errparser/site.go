@@ -0,0 +1,218 @@
+package errparser
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// SiteKind describes how the error of the site reaches the caller
+type SiteKind int
+
+const (
+	// SiteReturn is an error expression returned by a return statement, e.g. `return nil, err`
+	SiteReturn SiteKind = iota
+	// SiteBareReturn is a return statement without any values
+	// in a function with the named error results, e.g. `return`.
+	// The error is returned through the named result,
+	// so it should be wrapped before the return statement.
+	SiteBareReturn
+	// SiteTupleReturn is a return statement forwarding all the results
+	// of a function call, e.g. `return loadConfig(path)`.
+	// Only the error component of the call results should be wrapped.
+	SiteTupleReturn
+	// SiteDeferredAssign is an error expression assigned to the named error result
+	// within a deferred function literal, e.g. `err = cerr`
+	SiteDeferredAssign
+)
+
+func (k SiteKind) String() string {
+	switch k {
+	case SiteReturn:
+		return "return"
+	case SiteBareReturn:
+		return "bare return"
+	case SiteTupleReturn:
+		return "tuple return"
+	case SiteDeferredAssign:
+		return "deferred assignment"
+	default:
+		return fmt.Sprintf("SiteKind(%d)", int(k))
+	}
+}
+
+// ErrorSite is a place in the source code where an error is passed to the caller
+type ErrorSite struct {
+	// Pkg is the package the site belongs to
+	Pkg *packages.Package
+	// Kind describes how the error is passed to the caller
+	Kind SiteKind
+	// Node is the node to rewrite: the error expression for SiteReturn and SiteDeferredAssign,
+	// the return statement for SiteBareReturn and SiteTupleReturn
+	Node ast.Node
+	// Pos and End are the positions of the node within its file
+	Pos, End token.Position
+	// Source is the source text of the node
+	Source string
+	// Class tells where the error comes from. The tuple returns are always ClassCall;
+	// for the bare returns wrapping multiple errors it's the class of the first one.
+	Class ErrorClass
+
+	// Func is the function whose result is set, either *ast.FuncDecl or *ast.FuncLit
+	Func ast.Node
+	// FuncName is the qualified name of the function, similar to the one reported by the runtime:
+	// example.com/pkg.Load, example.com/pkg.(*Decoder).Decode, example.com/pkg.Load.func1.
+	// The package level function literals are named after the variable, e.g. example.com/pkg.handler.func1
+	FuncName string
+	// Recv is the receiver type of the method, e.g. *Decoder; empty for the functions
+	Recv string
+
+	// ResultIdxs are the indexes of the error results set by the site,
+	// each of them is wrapped with its own error number
+	ResultIdxs []int
+	// NumResults is the number of the function results
+	NumResults int
+	// ErrNames are the named error results in the order of ResultIdxs;
+	// set for SiteBareReturn and SiteDeferredAssign
+	ErrNames []*ast.Ident
+	// Call is the function call forwarded by SiteTupleReturn
+	Call *ast.CallExpr
+	// Codes are the error numbers pinned with the code directive in the order of ResultIdxs.
+	// The errors without a pinned code get the next free numbers.
+	Codes []int
+}
+
+// NumErrors returns the number of the errors wrapped by the site
+func (s ErrorSite) NumErrors() int {
+	return len(s.ResultIdxs)
+}
+
+// String returns the position of the site together with its function
+func (s ErrorSite) String() string {
+	return fmt.Sprintf("%s: %s of %s error in %s", s.Pos, s.Kind, s.Class, s.FuncName)
+}
+
+// compareSites orders the sites by the package import path,
+// the file name and the position within the file
+func compareSites(a, b ErrorSite) int {
+	return cmp.Or(
+		cmp.Compare(a.Pkg.PkgPath, b.Pkg.PkgPath),
+		cmp.Compare(a.Pos.Filename, b.Pos.Filename),
+		cmp.Compare(a.Pos.Offset, b.Pos.Offset),
+	)
+}
+
+// funcScope is the function the parsed sites belong to
+type funcScope struct {
+	node ast.Node
+	name string
+	recv string
+	// lits counts the function literals found directly within the function
+	lits *int
+}
+
+// declScope returns the scope of the function declaration
+func declScope(pkg *packages.Package, decl *ast.FuncDecl) funcScope {
+	scope := funcScope{
+		node: decl,
+		name: pkg.PkgPath + "." + decl.Name.Name,
+		lits: new(int),
+	}
+	if decl.Recv != nil && len(decl.Recv.List) > 0 {
+		scope.recv = types.ExprString(decl.Recv.List[0].Type)
+		if _, ok := decl.Recv.List[0].Type.(*ast.StarExpr); ok {
+			scope.name = fmt.Sprintf("%s.(%s).%s", pkg.PkgPath, scope.recv, decl.Name.Name)
+		} else {
+			scope.name = fmt.Sprintf("%s.%s.%s", pkg.PkgPath, scope.recv, decl.Name.Name)
+		}
+	}
+	return scope
+}
+
+// varScope returns the scope of the package level variable, holding its function literals
+func varScope(pkg *packages.Package, name string) funcScope {
+	return funcScope{
+		name: pkg.PkgPath + "." + name,
+		lits: new(int),
+	}
+}
+
+// lit returns the scope of the next function literal found within the scope
+func (s funcScope) lit(funcLit *ast.FuncLit) funcScope {
+	*s.lits++
+	return funcScope{
+		node: funcLit,
+		name: fmt.Sprintf("%s.func%d", s.name, *s.lits),
+		recv: s.recv,
+		lits: new(int),
+	}
+}
+
+// FuncName returns the qualified name of the function containing the node, named like ErrorSite.FuncName,
+// e.g. for the already wrapped errors found by the RetParamParser. It's empty if the node is not within
+// a function of the package files.
+func FuncName(pkg *packages.Package, node ast.Node) string {
+	for _, stxFile := range pkg.Syntax {
+		if node.Pos() < stxFile.FileStart || stxFile.FileEnd <= node.Pos() {
+			continue
+		}
+		for _, d := range stxFile.Decls {
+			if node.Pos() < d.Pos() || d.End() <= node.Pos() {
+				continue
+			}
+			switch decl := d.(type) {
+			case *ast.FuncDecl:
+				if decl.Body == nil {
+					return ""
+				}
+				return declScope(pkg, decl).find(decl.Body, node).name
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					valueSpec, ok := spec.(*ast.ValueSpec)
+					if !ok || len(valueSpec.Names) == 0 {
+						continue
+					}
+					for i, value := range valueSpec.Values {
+						if node.Pos() < value.Pos() || value.End() <= node.Pos() {
+							continue
+						}
+						name := valueSpec.Names[min(i, len(valueSpec.Names)-1)]
+						scope := varScope(pkg, name.Name).find(value, node)
+						if scope.node == nil {
+							// Not within a function literal of the variable
+							return ""
+						}
+						return scope.name
+					}
+				}
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// find returns the scope of the innermost function literal within the root containing the node,
+// the literals are counted like when parsing. It's the scope itself if the node is not within a literal.
+func (s funcScope) find(root ast.Node, node ast.Node) funcScope {
+	found, done := s, false
+	ast.Inspect(root, func(n ast.Node) bool {
+		if done {
+			return false
+		}
+		funcLit, ok := n.(*ast.FuncLit)
+		if !ok {
+			return true
+		}
+		litScope := s.lit(funcLit)
+		if funcLit.Pos() <= node.Pos() && node.Pos() < funcLit.End() {
+			found, done = litScope.find(funcLit.Body, node), true
+		}
+		return false
+	})
+	return found
+}
@@ -0,0 +1,54 @@
+package classify
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errNotFound = errors.New("not found")
+
+type MyError struct{}
+
+func (*MyError) Error() string { return "my error" }
+
+type store struct {
+	err error
+}
+
+func load(name string) (string, error) {
+	return "", nil
+}
+
+func (s *store) get(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("empty name")
+	}
+	if name == "eof" {
+		return "", io.EOF
+	}
+	if name == "missing" {
+		return "", errNotFound
+	}
+	if name == "invalid" {
+		return "", fmt.Errorf("invalid name %q", name)
+	}
+	if name == "custom" {
+		return "", &MyError{}
+	}
+	if s.err != nil {
+		return "", s.err
+	}
+	v, err := load(name)
+	if err != nil {
+		return "", fmt.Errorf("load %s: %w", name, err)
+	}
+	if v == "" {
+		return "", err
+	}
+	return v, validate(v)
+}
+
+func validate(v string) error {
+	return nil
+}
@@ -0,0 +1,42 @@
+package deferred
+
+import (
+	"errors"
+	"os"
+)
+
+func write(name string) (err error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+	defer func() {
+		err = errors.Join(err, os.Remove(name))
+	}()
+	defer func() {
+		err := errors.New("shadowed")
+		_ = err
+	}()
+	defer func() {
+		var err error
+		err = errors.New("declared in the deferred function")
+		_ = err
+	}()
+	defer func(err error) {
+		err = errors.New("parameter")
+		_ = err
+	}(nil)
+	defer func() {
+		if r := recover(); r != nil {
+			err = nil
+		}
+	}()
+
+	_, err = f.WriteString("data")
+	return
+}
@@ -0,0 +1,34 @@
+package funclits
+
+import "errors"
+
+var errFirst = errors.New("first")
+
+var handler = func(name string) error {
+	return errors.New("handler: " + name)
+}
+
+var handlers = map[string]func() error{
+	"first": func() error { return errFirst },
+}
+
+type group struct{}
+
+func (group) Go(f func() error) {}
+
+func run() {
+	var g group
+	g.Go(func() error {
+		return errors.New("in closure")
+	})
+}
+
+func newChecker() func() error {
+	return func() error { return errors.New("checker") }
+}
+
+func nested() error {
+	f := func() (int, error) { return 0, errors.New("nested") }
+	_, err := f()
+	return err
+}
@@ -0,0 +1,7 @@
+package api
+
+import "errors"
+
+func Get() error {
+	return errors.New("api")
+}
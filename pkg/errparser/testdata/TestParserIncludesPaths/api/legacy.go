@@ -0,0 +1,7 @@
+package api
+
+import "errors"
+
+func legacy() error {
+	return errors.New("legacy")
+}
@@ -0,0 +1,7 @@
+package store
+
+import "errors"
+
+func cached() error {
+	return errors.New("cache")
+}
@@ -0,0 +1,7 @@
+package store
+
+import "errors"
+
+func load() error {
+	return errors.New("store")
+}
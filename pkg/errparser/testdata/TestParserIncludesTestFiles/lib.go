@@ -0,0 +1,7 @@
+package lib
+
+import "errors"
+
+func Open(name string) error {
+	return errors.New("lib: " + name)
+}
@@ -0,0 +1,16 @@
+package lib_test
+
+import (
+	"errors"
+	"testing"
+)
+
+func openExternalFixture() error {
+	return errors.New("external test")
+}
+
+func TestOpenExternal(t *testing.T) {
+	if err := openExternalFixture(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
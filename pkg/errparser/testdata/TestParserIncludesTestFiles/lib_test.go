@@ -0,0 +1,16 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+)
+
+func openFixture() error {
+	return errors.New("internal test")
+}
+
+func TestOpen(t *testing.T) {
+	if err := openFixture(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
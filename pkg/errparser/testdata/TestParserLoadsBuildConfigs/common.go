@@ -0,0 +1,7 @@
+package configs
+
+import "errors"
+
+func common() error {
+	return errors.New("common")
+}
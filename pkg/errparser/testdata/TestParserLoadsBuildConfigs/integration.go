@@ -0,0 +1,9 @@
+//go:build integration
+
+package configs
+
+import "errors"
+
+func integration() error {
+	return errors.New("integration")
+}
@@ -0,0 +1,7 @@
+package configs
+
+import "errors"
+
+func platform() error {
+	return errors.New("windows")
+}
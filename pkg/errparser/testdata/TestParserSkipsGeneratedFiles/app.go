@@ -0,0 +1,7 @@
+package app
+
+import "errors"
+
+func run() error {
+	return errors.New("app")
+}
@@ -0,0 +1,39 @@
+package typed
+
+import "errors"
+
+// CodedError is a named interface embedding error
+type CodedError interface {
+	error
+	Code() int
+}
+
+// AliasError is an alias of error
+type AliasError = error
+
+// MyError is a concrete error type
+type MyError struct{}
+
+func (*MyError) Error() string {
+	return "my error"
+}
+
+func plain() (int, error) {
+	return 0, errors.New("plain")
+}
+
+func aliased() AliasError {
+	return errors.New("aliased")
+}
+
+func coded() CodedError {
+	return nil
+}
+
+func concrete() *MyError {
+	return &MyError{}
+}
+
+func concreteAsError() error {
+	return &MyError{}
+}
@@ -0,0 +1,295 @@
+// Package explain finds the errors of the code returned by the generated Code function,
+// e.g. 34-12-7, by scanning the source tree for the wrapper calls
+package explain
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/anjankow/errnumgen/pkg/generator"
+)
+
+// Options of the search
+type Options struct {
+	// OutPackageName is the name of the package holding the error numbers
+	OutPackageName string
+	// OutImportPath is the import path of the package holding the error numbers.
+	// If empty, the imports with the last element equal to OutPackageName are matched.
+	OutImportPath string
+	// ConstPrefix precedes the number in the names of the error number consts, e.g. N_ of N_12
+	ConstPrefix string
+	// CodePrefix, NumPrefix and CodeSeparator are the format of the code,
+	// the same as set for the generator, see generator.GenOptions
+	CodePrefix    string
+	NumPrefix     string
+	CodeSeparator string
+	// Context is the number of the source lines printed around each call
+	Context int
+}
+
+func GetDefaultOptions() Options {
+	gopts := generator.GetDefaultGenOptions()
+	return Options{
+		OutPackageName: gopts.OutPackageName,
+		ConstPrefix:    gopts.ConstPrefix,
+		CodePrefix:     gopts.CodePrefix,
+		NumPrefix:      gopts.NumPrefix,
+		CodeSeparator:  gopts.CodeSeparator,
+		Context:        2,
+	}
+}
+
+// Link is an error of the chain, wrapped with the number
+type Link struct {
+	Num int
+	// Calls are the wrapper calls using the number; more than one if the number is duplicated,
+	// none if it's not found in the source tree
+	Calls []Call
+}
+
+// Call is a wrapper call found in the source tree, e.g. errnums.New(errnums.N_12, err)
+type Call struct {
+	Pos token.Position
+	// FuncName is the qualified name of the function containing the call, the same as in the registry
+	FuncName string
+	// Lines are the source lines around the call
+	Lines []Line
+}
+
+// Line is a numbered source line
+type Line struct {
+	Num  int
+	Text string
+}
+
+// ParseCode returns the error numbers of the code in the format of the options,
+// from the outermost to the innermost one
+func ParseCode(code string, opts Options) ([]int, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, fmt.Errorf("empty error code")
+	}
+	invalidErr := fmt.Errorf("invalid error code %q, expected numbers like %s%s1%s%s2", code, opts.CodePrefix, opts.NumPrefix, opts.CodeSeparator, opts.NumPrefix)
+
+	code, ok := strings.CutPrefix(code, opts.CodePrefix)
+	if !ok {
+		return nil, invalidErr
+	}
+	var nums []int
+	for part := range strings.SplitSeq(code, opts.CodeSeparator) {
+		numStr, ok := strings.CutPrefix(part, opts.NumPrefix)
+		if !ok {
+			return nil, invalidErr
+		}
+		num, err := strconv.Atoi(numStr)
+		if err != nil || num <= 0 {
+			return nil, invalidErr
+		}
+		nums = append(nums, num)
+	}
+	return nums, nil
+}
+
+// Explain finds the wrapper calls of each number of the code within the directory, recursively.
+// The links are returned in the order of the code, from the outermost to the innermost error.
+func Explain(dir string, code string, opts Options) ([]Link, error) {
+	nums, err := ParseCode(code, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	f := finder{
+		opts:  opts,
+		nums:  make(map[int]bool),
+		calls: make(map[int][]Call),
+	}
+	for _, num := range nums {
+		f.nums[num] = true
+	}
+	if err := f.walk(dir); err != nil {
+		return nil, err
+	}
+
+	links := make([]Link, len(nums))
+	for i, num := range nums {
+		links[i] = Link{Num: num, Calls: f.calls[num]}
+	}
+	return links, nil
+}
+
+// finder collects the wrapper calls of the searched numbers
+type finder struct {
+	opts  Options
+	nums  map[int]bool
+	calls map[int][]Call
+
+	// The currently inspected file
+	fset       *token.FileSet
+	lines      []string
+	qualifiers map[string]bool
+}
+
+// walk inspects the Go files within the directory, skipping the same directories as the go tool:
+// vendor, testdata and the ones starting with . or _
+func (f *finder) walk(dir string) error {
+	importPaths := make(map[string]string)
+	return filepath.WalkDir(dir, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if filename != dir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(filename) != ".go" {
+			return nil
+		}
+
+		fileDir := filepath.Dir(filename)
+		importPath, ok := importPaths[fileDir]
+		if !ok {
+			// Without the module the functions are qualified with the package name only
+			importPath, _ = generator.ImportPath(fileDir)
+			importPaths[fileDir] = importPath
+		}
+		return f.inspectFile(filename, importPath)
+	})
+}
+
+// inspectFile finds the wrapper calls within the file
+func (f *finder) inspectFile(filename string, importPath string) error {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	f.fset = token.NewFileSet()
+	stxFile, err := parser.ParseFile(f.fset, filename, content, 0)
+	if err != nil {
+		// Not a valid Go file, can't contain the calls
+		return nil
+	}
+	f.lines = strings.Split(string(content), "\n")
+
+	f.qualifiers = make(map[string]bool)
+	for _, imp := range stxFile.Imports {
+		impPath, _ := strconv.Unquote(imp.Path.Value)
+		if f.opts.OutImportPath != "" && impPath != f.opts.OutImportPath {
+			continue
+		}
+		if f.opts.OutImportPath == "" && path.Base(impPath) != f.opts.OutPackageName {
+			continue
+		}
+		name := f.opts.OutPackageName
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		f.qualifiers[name] = true
+	}
+	if len(f.qualifiers) == 0 {
+		return nil
+	}
+
+	if importPath == "" {
+		importPath = stxFile.Name.Name
+	}
+	for _, decl := range stxFile.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Body != nil {
+				f.inspect(d.Body, funcName(importPath, d))
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				valueSpec, ok := spec.(*ast.ValueSpec)
+				if !ok || len(valueSpec.Names) == 0 {
+					continue
+				}
+				for i, value := range valueSpec.Values {
+					name := valueSpec.Names[min(i, len(valueSpec.Names)-1)]
+					f.inspect(value, importPath+"."+name.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// inspect finds the wrapper calls within the node of the named function.
+// The function literals are named after their parent and their index, e.g. Load.func1.
+func (f *finder) inspect(node ast.Node, name string) {
+	lits := 0
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			lits++
+			f.inspect(n.Body, fmt.Sprintf("%s.func%d", name, lits))
+			return false
+		case *ast.CallExpr:
+			if num, ok := f.wrapperNum(n); ok && f.nums[num] {
+				f.calls[num] = append(f.calls[num], f.call(n, name))
+			}
+		}
+		return true
+	})
+}
+
+// wrapperNum returns the error number of the wrapper call, e.g. errnums.New(errnums.N_12, err)
+func (f *finder) wrapperNum(call *ast.CallExpr) (int, bool) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "New" || len(call.Args) != 2 {
+		return 0, false
+	}
+	// The identifiers declared within the file, e.g. a local variable, are not the package
+	if ident, ok := sel.X.(*ast.Ident); !ok || ident.Obj != nil || !f.qualifiers[ident.Name] {
+		return 0, false
+	}
+	numSel, ok := call.Args[0].(*ast.SelectorExpr)
+	if !ok {
+		return 0, false
+	}
+	numStr, ok := strings.CutPrefix(numSel.Sel.Name, f.opts.ConstPrefix)
+	if !ok {
+		return 0, false
+	}
+	num, err := strconv.Atoi(numStr)
+	return num, err == nil
+}
+
+// call describes the wrapper call together with its surrounding lines
+func (f *finder) call(call *ast.CallExpr, funcName string) Call {
+	pos := f.fset.Position(call.Pos())
+	c := Call{
+		Pos:      pos,
+		FuncName: funcName,
+	}
+	first := max(pos.Line-f.opts.Context, 1)
+	last := min(pos.Line+f.opts.Context, len(f.lines))
+	for num := first; num <= last; num++ {
+		c.Lines = append(c.Lines, Line{Num: num, Text: f.lines[num-1]})
+	}
+	return c
+}
+
+// funcName returns the qualified name of the function, e.g. example.com/pkg.(*Decoder).Decode
+func funcName(importPath string, decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return importPath + "." + decl.Name.Name
+	}
+	recv := types.ExprString(decl.Recv.List[0].Type)
+	if _, ok := decl.Recv.List[0].Type.(*ast.StarExpr); ok {
+		return fmt.Sprintf("%s.(%s).%s", importPath, recv, decl.Name.Name)
+	}
+	return fmt.Sprintf("%s.%s.%s", importPath, recv, decl.Name.Name)
+}
@@ -0,0 +1,95 @@
+package explain_test
+
+import (
+	"path"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/anjankow/errnumgen/pkg/explain"
+)
+
+func TestParseCode(t *testing.T) {
+	opts := explain.GetDefaultOptions()
+	nums, err := explain.ParseCode("34-12-7\n", opts)
+	if err != nil {
+		t.Fatalf("failed to parse the code: %v", err)
+	}
+	if exp := []int{34, 12, 7}; !slices.Equal(nums, exp) {
+		t.Errorf("expected %v, found %v", exp, nums)
+	}
+
+	for _, code := range []string{"", "34--7", "N_34", "-1"} {
+		if _, err := explain.ParseCode(code, opts); err == nil {
+			t.Errorf("expected an error for the code %q", code)
+		}
+	}
+
+	// The custom format
+	opts.CodePrefix = "PAY-"
+	opts.NumPrefix = "E"
+	opts.CodeSeparator = "."
+	nums, err = explain.ParseCode("PAY-E0042.E0007", opts)
+	if err != nil {
+		t.Fatalf("failed to parse the code: %v", err)
+	}
+	if exp := []int{42, 7}; !slices.Equal(nums, exp) {
+		t.Errorf("expected %v, found %v", exp, nums)
+	}
+	for _, code := range []string{"E0042.E0007", "PAY-0042.E0007", "PAY-E0042-E0007"} {
+		if _, err := explain.ParseCode(code, opts); err == nil {
+			t.Errorf("expected an error for the code %q", code)
+		}
+	}
+}
+
+func TestExplainFindsWrappers(t *testing.T) {
+	dir := path.Join("./testdata/", t.Name())
+	pkgPath := "github.com/anjankow/errnumgen/pkg/explain/testdata/" + t.Name()
+
+	opts := explain.GetDefaultOptions()
+	opts.Context = 1
+	links, err := explain.Explain(dir, "34-12-7-99", opts)
+	if err != nil {
+		t.Fatalf("failed to explain: %v", err)
+	}
+
+	type call struct {
+		num      int
+		filename string
+		line     int
+		funcName string
+	}
+	expCalls := []call{
+		{34, "store.go", 19, pkgPath + ".(*Store).Get"},
+		{12, "store.go", 21, pkgPath + ".(*Store).Get"},
+		{7, "store.go", 22, pkgPath + ".(*Store).Get.func1"},
+		{7, filepath.Join("sub", "sub.go"), 10, pkgPath + "/sub.handler.func1"},
+	}
+	var found []call
+	for _, link := range links {
+		for _, c := range link.Calls {
+			found = append(found, call{link.Num, c.Pos.Filename, c.Pos.Line, c.FuncName})
+		}
+	}
+	for i := range expCalls {
+		expCalls[i].filename = filepath.Join(dir, expCalls[i].filename)
+	}
+	if !slices.Equal(found, expCalls) {
+		t.Errorf("expected calls:\n%+v\nfound:\n%+v", expCalls, found)
+	}
+
+	if len(links) != 4 || links[3].Num != 99 || len(links[3].Calls) != 0 {
+		t.Errorf("expected the last number not found, got %+v", links)
+	}
+
+	// The surrounding lines are included
+	expLines := []explain.Line{
+		{Num: 18, Text: "\tif key == \"\" {"},
+		{Num: 19, Text: "\t\treturn en.New(en.N_34, errors.New(\"empty key\"))"},
+		{Num: 20, Text: "\t}"},
+	}
+	if lines := links[0].Calls[0].Lines; !slices.Equal(lines, expLines) {
+		t.Errorf("expected lines %q, found %q", expLines, lines)
+	}
+}
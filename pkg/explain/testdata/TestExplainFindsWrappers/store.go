@@ -0,0 +1,30 @@
+package store
+
+import (
+	"errors"
+
+	en "example.com/app/errnums"
+)
+
+type recorder struct{}
+
+func (recorder) New(code int, err error) error {
+	return err
+}
+
+type Store struct{}
+
+func (s *Store) Get(key string) error {
+	if key == "" {
+		return en.New(en.N_34, errors.New("empty key"))
+	}
+	return en.New(en.N_12, func() error {
+		return en.New(en.N_7, errors.New("not found"))
+	}())
+}
+
+func record(err error) error {
+	// Not the output package
+	en := recorder{}
+	return en.New(34, err)
+}
@@ -0,0 +1,11 @@
+package sub
+
+import (
+	"errors"
+
+	"example.com/app/errnums"
+)
+
+var handler = func() error {
+	return errnums.New(errnums.N_7, errors.New("duplicated"))
+}
@@ -0,0 +1,55 @@
+package generator
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// applyEdits replaces the nodes of the edits within the file content in one pass.
+// The edits are not expected to overlap, the nested sites are reported by Edits;
+// the overlapping ones are not applied and are reported as errors.
+func applyEdits(content string, edits []Edit) (string, error) {
+	edits = slices.Clone(edits)
+	// The outer node goes first if both start at the same offset
+	slices.SortStableFunc(edits, func(a, b Edit) int {
+		return cmp.Or(
+			cmp.Compare(a.Site.Pos.Offset, b.Site.Pos.Offset),
+			cmp.Compare(b.Site.End.Offset, a.Site.End.Offset),
+		)
+	})
+
+	var errs []error
+	var newContent strings.Builder
+	newContent.Grow(len(content))
+	// last is the last applied edit, the content is copied up to its end
+	var last *Edit
+	for i := range edits {
+		edit := &edits[i]
+		if edit.Site.Pos.Offset < 0 || edit.Site.End.Offset > len(content) || edit.Site.Pos.Offset > edit.Site.End.Offset {
+			errs = append(errs, fmt.Errorf("%s: edit out of the file content", edit.Site.Pos))
+			continue
+		}
+		if last != nil && edit.Site.Pos.Offset < last.Site.End.Offset {
+			errs = append(errs, fmt.Errorf("%s: %s overlaps with %s at %s, not applied",
+				edit.Site.Pos, edit.Site.Kind, last.Site.Kind, last.Site.Pos))
+			continue
+		}
+
+		prevEnd := 0
+		if last != nil {
+			prevEnd = last.Site.End.Offset
+		}
+		newContent.WriteString(content[prevEnd:edit.Site.Pos.Offset])
+		newContent.WriteString(edit.NewText)
+		last = edit
+	}
+	if last != nil {
+		newContent.WriteString(content[last.Site.End.Offset:])
+	} else {
+		newContent.WriteString(content)
+	}
+	return newContent.String(), errors.Join(errs...)
+}
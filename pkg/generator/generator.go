@@ -1,18 +1,21 @@
 package generator
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"io/fs"
+	"log"
+	"maps"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"text/template"
 
+	"github.com/anjankow/errnumgen/pkg/errparser"
 	"golang.org/x/tools/go/packages"
 )
 
@@ -22,16 +25,88 @@ type Generator struct {
 	readFile ReadFileFunc
 
 	outPathAbs string
+	// outImportPath is the import path of the output package, empty if not known
+	outImportPath string
+	// qualifiers hold the names the output package is referred to within each updated file
+	qualifiers map[string]string
+	// localIdents hold the offsets of the identifiers declared within each file, see declaredLocally
+	localIdents map[string]map[int]bool
+	// pkgNames hold the names declared in the package block of each package by its ID, see packageNames
+	pkgNames map[string]map[string]bool
 	// lastErrNum is the last err number, the next generated error should start from this one +1
 	lastErrNum int
+	// pinnedNums are the numbers pinned with the code directive, they are not assigned to other errors
+	pinnedNums map[int]bool
+	// wrapped counts the already wrapped errors found while parsing
+	wrapped int
+	// wrappedNums are the already wrapped errors found while parsing, with their numbers
+	wrappedNums []wrappedNum
+	// parsedFiles are the files parsed in this run and fileNums all wrapped errors found within them, see ParseFile
+	parsedFiles map[string]bool
+	fileNums    []wrappedNum
+	// sites and siteNums hold the sites of the last Edits call together with their error numbers
+	sites    []errparser.ErrorSite
+	siteNums [][]int
+	// updated holds the file contents updated by the last Generate call
+	updated map[string]string
+	// registry is updated with the sites and the wrapped numbers by the last Generate call.
+	// The numbers not found in it are retired, they are kept in the output file and never reassigned.
+	registry Registry
+	retired  map[int]bool
+	// tmpl holds the output templates, outputs maps the rendered files to their templates
+	tmpl    *template.Template
+	outputs map[string]string
+	summary Summary
+}
+
+// wrappedNum is an error already wrapped with the number, e.g. errnums.New(errnums.N_12, err)
+type wrappedNum struct {
+	pkg  *packages.Package
+	call *ast.CallExpr
+	num  int
+}
+
+// Summary counts the errors by the change made to them
+type Summary struct {
+	// Added is the number of the newly wrapped errors
+	Added int
+	// Renumbered is the number of the already wrapped errors getting a new number
+	Renumbered int
+	// Skipped is the number of the already wrapped errors left unchanged
+	Skipped int
+	// Retired is the number of the error numbers not used in the source anymore, counted by Generate
+	Retired int
 }
 
 type GenOptions struct {
 	OutPackageName string
 	// OutPath is the path of the output file containing error enumeration
 	OutPath string
-	DryRun  bool
-	Reader  ReadFileFunc
+	// OutImportPath is the import path of the output package, added to the updated files.
+	// If empty, it's found from the module path in go.mod.
+	OutImportPath string
+	// DryRun is set if the updated files are not written, e.g. only listed or printed as a diff.
+	// Then the output package import path may be unknown, the updated files don't import it.
+	DryRun bool
+	Reader ReadFileFunc
+
+	// ConstPrefix precedes the number in the names of the error number consts, e.g. N_ of N_12.
+	// It must form an exported Go identifier and must not end with a digit.
+	ConstPrefix string
+	// CodeWidth is the minimal number of digits of each number in the runtime code, padded with zeros
+	CodeWidth int
+	// NumPrefix precedes each number in the runtime code, e.g. E of E0042
+	NumPrefix string
+	// CodeSeparator joins the numbers of the wrapped errors in the runtime code
+	CodeSeparator string
+	// CodePrefix precedes the whole runtime code, e.g. PAY- of PAY-E0042.E0007
+	CodePrefix string
+	// Template is the path of the template file rendering the output file, or of the template directory.
+	// Each template of the directory renders the file of the same name without the .tmpl extension
+	// next to the output file, e.g. errnums.go.tmpl renders errnums.go. The templates starting with _
+	// are not rendered. The templates get TemplateData, see also the helper functions in templateFuncs.
+	// If empty, the embedded template is used.
+	Template string
 }
 
 type ReadFileFunc func(filename string) ([]byte, error)
@@ -42,7 +117,35 @@ func GetDefaultGenOptions() GenOptions {
 		OutPath:        "./errnums/errnums.go",
 		DryRun:         false,
 		Reader:         os.ReadFile,
+		ConstPrefix:    defaultConstPrefix,
+		CodeSeparator:  defaultCodeSeparator,
+	}
+}
+
+const (
+	defaultConstPrefix   = "N_"
+	defaultCodeSeparator = "-"
+)
+
+// validateCodeFormat checks if the names of the consts and the runtime codes can be read back
+func validateCodeFormat(opts GenOptions) error {
+	name := opts.ConstPrefix + "1"
+	if !token.IsIdentifier(name) || !token.IsExported(name) {
+		return fmt.Errorf("invalid const prefix %q, the const names like %s must be exported Go identifiers", opts.ConstPrefix, name)
+	}
+	if last := opts.ConstPrefix[len(opts.ConstPrefix)-1]; last >= '0' && last <= '9' {
+		return fmt.Errorf("invalid const prefix %q, it must not end with a digit", opts.ConstPrefix)
+	}
+	if opts.CodeWidth < 0 || opts.CodeWidth > 20 {
+		return fmt.Errorf("invalid code width %d, expected 0 to 20 digits", opts.CodeWidth)
 	}
+	if strings.ContainsAny(opts.CodeSeparator, "0123456789") {
+		return fmt.Errorf("invalid code separator %q, it must not contain digits", opts.CodeSeparator)
+	}
+	if strings.Contains(opts.NumPrefix, opts.CodeSeparator) {
+		return fmt.Errorf("invalid number prefix %q, it must not contain the code separator %q", opts.NumPrefix, opts.CodeSeparator)
+	}
+	return nil
 }
 
 func New(opts GenOptions) (Generator, error) {
@@ -62,11 +165,104 @@ func New(opts GenOptions) (Generator, error) {
 		return Generator{}, fmt.Errorf("invalid output path %q, expected an absolute or a relative path, not just a filename", opts.OutPath)
 	}
 
-	return Generator{
-		opts:       opts,
-		readFile:   os.ReadFile,
-		outPathAbs: outPathAbs,
-	}, nil
+	if opts.ConstPrefix == "" {
+		opts.ConstPrefix = defaultConstPrefix
+	}
+	if opts.CodeSeparator == "" {
+		opts.CodeSeparator = defaultCodeSeparator
+	}
+	if err := validateCodeFormat(opts); err != nil {
+		return Generator{}, err
+	}
+
+	readFile := opts.Reader
+	if readFile == nil {
+		readFile = os.ReadFile
+	}
+
+	outImportPath := opts.OutImportPath
+	if outImportPath == "" {
+		outImportPath, err = findOutImportPath(outPathAbs)
+		if err != nil && !opts.DryRun {
+			// The updated files wouldn't compile without the import
+			return Generator{}, fmt.Errorf("failed to find the import path of the output package, set it explicitly: %w", err)
+		}
+		if err != nil {
+			log.Default().Printf("the output package won't be imported by the updated files: %v", err)
+		}
+	}
+
+	g := Generator{
+		opts:          opts,
+		readFile:      readFile,
+		outPathAbs:    outPathAbs,
+		outImportPath: outImportPath,
+		qualifiers:    make(map[string]string),
+		localIdents:   make(map[string]map[int]bool),
+		pkgNames:      make(map[string]map[string]bool),
+		parsedFiles:   make(map[string]bool),
+	}
+	if err := g.loadTemplates(); err != nil {
+		return Generator{}, err
+	}
+	return g, nil
+}
+
+// ReadOutputFile reserves the error numbers declared by the existing output file and recorded by the registry,
+// so that the numbers of the removed errors are never reassigned, even if the output file is removed. It's called by Generate.
+// It fails if the output file or the registry names the numbers with another const prefix,
+// they wouldn't be recognized in the source.
+func (g *Generator) ReadOutputFile() error {
+	registry, err := ReadRegistry(g.readFile, g.RegistryPath())
+	if err != nil {
+		return err
+	}
+	for _, entry := range registry.Errors {
+		if entry.Name != g.constName(entry.Code) {
+			return fmt.Errorf("the registry names the error number %d %s, not with the const prefix %q; use the prefix the numbers were generated with",
+				entry.Code, entry.Name, g.opts.ConstPrefix)
+		}
+		g.ReserveNums(entry.Code)
+	}
+
+	content, err := g.readFile(g.outPathAbs)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the output file: %w", err)
+	}
+
+	stxFile, err := parser.ParseFile(token.NewFileSet(), g.outPathAbs, content, parser.SkipObjectResolution)
+	if err != nil {
+		return fmt.Errorf("failed to parse the output file: %w", err)
+	}
+	for _, decl := range stxFile.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+			for _, name := range valueSpec.Names {
+				if num, ok := g.NumFromName(name.Name); ok {
+					g.ReserveNums(num)
+					continue
+				}
+				if typ, ok := valueSpec.Type.(*ast.Ident); ok && typ.Name == "ErrNum" {
+					return fmt.Errorf("the output file declares the error number %s, not with the const prefix %q; use the prefix the numbers were generated with",
+						name.Name, g.opts.ConstPrefix)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ReserveNums marks all numbers up to num as used, the new numbers are assigned after it.
+// It's used when the existing numbers are known from elsewhere than the parsed sources.
+func (g *Generator) ReserveNums(num int) {
+	g.lastErrNum = max(g.lastErrNum, num)
 }
 
 func (g *Generator) ParseRetParam(pkg *packages.Package, retParam ast.Expr) (out ast.Expr, skip bool) {
@@ -75,52 +271,110 @@ func (g *Generator) ParseRetParam(pkg *packages.Package, retParam ast.Expr) (out
 
 	// Check if the wrapper has already been generated.
 	// Set skip to false if anything is not as expected to generate the wrapper after parsing.
-	retCallStmt, ok := retParam.(*ast.CallExpr)
+	retCallStmt, ok := g.asWrapper(pkg, retParam)
+	if !ok {
+		return
+	}
+
+	// Already generated.
+	skip = true
+	g.wrapped++
+
+	// Check if the error number is not bigger than
+	// the latest found.
+	num, ok := g.wrapperNum(retCallStmt)
 	if !ok {
 		return
 	}
+	g.wrappedNums = append(g.wrappedNums, wrappedNum{pkg: pkg, call: retCallStmt, num: num})
+	// If the last found error is smaller than the current one,
+	// assign it to the latest found
+	if g.lastErrNum < num {
+		g.lastErrNum = num
+	}
+
+	return
+}
+
+// ParseFile records the file parsed in this run and the numbers used within it, it's set as errparser.ParserOptions.FileParser.
+// The numbers are retired only if they are not found in the files they were last found in,
+// so without it only the numbers of the removed files are. The numbers used outside of the parsed errors,
+// e.g. within the ignored functions, are neither retired nor reassigned.
+func (g *Generator) ParseFile(pkg *packages.Package, file *ast.File) {
+	g.parsedFiles[pkg.Fset.PositionFor(file.FileStart, false).Filename] = true
+	ast.Inspect(file, func(n ast.Node) bool {
+		expr, ok := n.(ast.Expr)
+		if !ok {
+			return true
+		}
+		if wrapper, ok := g.asWrapper(pkg, expr); ok {
+			if num, ok := g.wrapperNum(wrapper); ok {
+				g.fileNums = append(g.fileNums, wrappedNum{pkg: pkg, call: wrapper, num: num})
+				g.lastErrNum = max(g.lastErrNum, num)
+			}
+		}
+		return true
+	})
+}
+
+// asWrapper returns the call expression if the expression is the call of the wrapper:
+// errnums.New(errnums.N_12, err).
+// The package qualifier is resolved to the output package, so the aliased imports are recognized
+// and the local identifiers named after the output package are not.
+func (g *Generator) asWrapper(pkg *packages.Package, expr ast.Expr) (*ast.CallExpr, bool) {
+	callExpr, ident, ok := wrapperCall(expr)
+	if !ok || !g.isOutPackage(pkg, ident) {
+		return nil, false
+	}
+	return callExpr, true
+}
+
+// wrapperCall returns the call expression and its package qualifier if the expression
+// has the shape of the wrapper call: pkg.New(...). The qualifier is not resolved.
+func wrapperCall(expr ast.Expr) (*ast.CallExpr, *ast.Ident, bool) {
+	callExpr, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return nil, nil, false
+	}
 	// Read the function name from the selector expr
-	selExpr, selOK := retCallStmt.Fun.(*ast.SelectorExpr)
+	selExpr, selOK := callExpr.Fun.(*ast.SelectorExpr)
 	if !selOK || selExpr.Sel.Name != "New" {
-		return
+		return nil, nil, false
 	}
 
 	// Identifier object holds the package name
 	ident, identOK := selExpr.X.(*ast.Ident)
-	if !identOK || !(ident.Name == g.opts.OutPackageName) {
-		return
+	if !identOK {
+		return nil, nil, false
 	}
+	return callExpr, ident, true
+}
 
-	// Already generated.
-	skip = true
-
-	// Check if the error number is not bigger than
-	// the latest found.
-	if len(retCallStmt.Args) != 2 {
-		return
+// wrapperNum reads the error number from the wrapper call
+func (g *Generator) wrapperNum(wrapper *ast.CallExpr) (int, bool) {
+	if len(wrapper.Args) != 2 {
+		return 0, false
 	}
 
-	numArg := retCallStmt.Args[0]
-	selExpr, selOK = numArg.(*ast.SelectorExpr)
+	numArg := wrapper.Args[0]
+	selExpr, selOK := numArg.(*ast.SelectorExpr)
 	if !selOK {
-		return
+		return 0, false
 	}
-	numName := selExpr.Sel.Name
-	numStr, ok := strings.CutPrefix(numName, constErrPrefix)
+	return g.NumFromName(selExpr.Sel.Name)
+}
+
+// NumFromName reads the error number from the name of its const, e.g. N_12
+func (g *Generator) NumFromName(name string) (int, bool) {
+	numStr, ok := strings.CutPrefix(name, g.opts.ConstPrefix)
 	if !ok {
-		return
+		return 0, false
 	}
 	num, err := strconv.Atoi(numStr)
 	if err != nil {
-		return
+		return 0, false
 	}
-	// If the last found error is smaller than the current one,
-	// assign it to the latest found
-	if g.lastErrNum < num {
-		g.lastErrNum = num
-	}
-
-	return
+	return num, true
 }
 
 func makeErrorMsgf(pkg *packages.Package, node ast.Node, message string, args ...any) string {
@@ -140,7 +394,7 @@ func makeErrorMsgf(pkg *packages.Package, node ast.Node, message string, args ..
 	}
 
 	ln := file.Line(node.Pos())
-	filename := getFilename(pkg, node.Pos())
+	filename := file.Name()
 
 	msg := fmt.Sprintf("%s:%v - %s\n", filename, ln, message)
 	if len(args) > 0 {
@@ -149,110 +403,399 @@ func makeErrorMsgf(pkg *packages.Package, node ast.Node, message string, args ..
 	return msg
 }
 
-const constErrPrefix = "N_"
+// constName returns the name of the error number const, e.g. N_12
+func (g *Generator) constName(num int) string {
+	return g.opts.ConstPrefix + strconv.Itoa(num)
+}
+
+// Edit replaces the node of the site with the new text, wrapping its errors with the numbers
+type Edit struct {
+	Site errparser.ErrorSite
+	// NewText replaces the site node, between the site offsets
+	NewText string
+	// Nums are the error numbers assigned to the errors of the site
+	Nums []int
+	// Names are the names of the consts of Nums, e.g. N_12
+	Names []string
+	// Renumber is set if the errors are already wrapped and only the numbers are replaced
+	Renumber bool
+}
 
-func (g *Generator) Generate(errNodesMap map[*packages.Package][]ast.Node) (fileContents map[string]string, outFilePath string, err error) {
-	// Init updated file contents with the out file
-	fileContents = make(map[string]string, len(errNodesMap))
+// String describes the edit together with the position of the site, e.g.
+// /src/pkg/file.go:12:9: wrap return of sentinel error with N_3
+func (e Edit) String() string {
+	action := "wrap"
+	if e.Renumber {
+		action = "renumber"
+	}
+	return fmt.Sprintf("%s: %s %s of %s error with %s", e.Site.Pos, action, e.Site.Kind, e.Site.Class, strings.Join(e.Names, ", "))
+}
+
+// Edits assigns the error numbers to the sites and returns the edits wrapping their errors.
+// The sites are expected in the order returned by the parser, the new numbers are assigned in this order.
+// The sites that don't need to be changed, e.g. the pinned number is already set, have no edit.
+// The sites nested in another edited site can't be wrapped together with it, they get no edit and are reported as errors.
+func (g *Generator) Edits(sites []errparser.ErrorSite) ([]Edit, error) {
+	edits, _, err := g.edits(sites)
+	return edits, err
+}
+
+// edits returns the edits of the sites together with the original contents of the edited files
+func (g *Generator) edits(sites []errparser.ErrorSite) ([]Edit, map[string]string, error) {
+	// The pinned numbers are reserved before assigning the new ones
+	if err := g.collectPinnedNums(sites); err != nil {
+		return nil, nil, err
+	}
+
+	// The error numbers of each site, a site may need more than one number
+	errNums := make([][]int, len(sites))
 
 	var errs []error
-	for pkg, errNodes := range errNodesMap {
-		// Start from the end of the slice to update the file from the end
-		// maintaining the correct positions of the previous nodes
-		for i := len(errNodes) - 1; i >= 0; i-- {
-			errNode := errNodes[i]
-			filename := getFilename(pkg, errNode.Pos())
-
-			// Get the file content
-			content, ok := fileContents[filename]
-			if !ok {
-				// Read it
-				originalContent, err := g.readFile(filename)
-				if err != nil {
-					errs = append(errs, errors.New(makeErrorMsgf(pkg, errNode, "failed to read: %v", err)))
-					continue
-				}
-				content = string(originalContent)
-			}
+	edits := make([]Edit, 0, len(sites))
+	contents := make(map[string]string)
+	unreadable := make(map[string]bool)
+	for i, site := range sites {
+		filename := site.Pos.Filename
+		if unreadable[filename] {
+			continue
+		}
 
-			// Read the retParam value
-			fposStart := pkg.Fset.Position(errNode.Pos())
-			fposEnd := pkg.Fset.Position(errNode.End())
-			errorContent := content[fposStart.Offset:fposEnd.Offset]
-
-			errNum := g.lastErrNum + i + 1
-			// Now wrap the error in the wrapper like:
-			// errnums.New(errnums.N_12, errors.New("original error"))
-			newErrorContent := fmt.Sprintf("%s.New(%s.%s%v, %s)",
-				g.opts.OutPackageName, g.opts.OutPackageName, constErrPrefix, errNum, errorContent)
-			_, err := parser.ParseExpr(newErrorContent)
+		// Get the file content
+		content, ok := contents[filename]
+		if !ok {
+			// Read it
+			originalContent, err := g.readFile(filename)
 			if err != nil {
-				// It's a bug!
-				return nil, "", errors.New(makeErrorMsgf(pkg, errNode, "failed to parse modified statement: %+v\n%+v", err, newErrorContent))
+				errs = append(errs, errors.New(makeErrorMsgf(site.Pkg, site.Node, "failed to read: %v", err)))
+				unreadable[filename] = true
+				continue
 			}
+			content = string(originalContent)
+			contents[filename] = content
+			g.qualifiers[filename] = g.outQualifier(site.Pkg, filename, content)
+		}
 
-			file := pkg.Fset.File(errNode.Pos())
-			if file == nil {
-				errs = append(errs, fmt.Errorf("file not found within the original files: %s", filename))
+		// The sites of a file are ordered by their position. A site nested in the preceding edited one,
+		// e.g. the return of a wrapped function literal, can't be rewritten together with it.
+		// It gets no number, the conflict is reported and one of them needs to be ignored.
+		if len(edits) > 0 {
+			outer := edits[len(edits)-1].Site
+			if outer.Pos.Filename == filename && site.Pos.Offset < outer.End.Offset {
+				errs = append(errs, errors.New(makeErrorMsgf(site.Pkg, site.Node, "%s is nested in the %s at %s, they can't be wrapped together; use //errnumgen:ignore to leave one of them unwrapped",
+					site.Kind, outer.Kind, outer.Pos)))
 				continue
 			}
+		}
 
-			start := file.Position(errNode.Pos())
-			stop := file.Position(errNode.End())
+		// Assign the numbers in the order of the sites
+		errNums[i] = g.assignNums(site)
+		start, stop := site.Pos.Offset, site.End.Offset
+		newErrorContent, err := g.rewriteNode(content, site, errNums[i])
+		if err != nil {
+			// It's a bug!
+			return nil, nil, errors.New(makeErrorMsgf(site.Pkg, site.Node, "failed to parse modified statement: %+v\n%+v", err, newErrorContent))
+		}
+		if newErrorContent == content[start:stop] {
+			// Nothing changed, e.g. the pinned number is already set
+			continue
+		}
 
-			newContent := content[0:start.Offset] +
-				newErrorContent +
-				content[stop.Offset:]
+		// The already wrapped expressions get only the new numbers
+		expr, _ := site.Node.(ast.Expr)
+		_, renumber := g.asWrapper(site.Pkg, expr)
+		names := make([]string, len(errNums[i]))
+		for j, num := range errNums[i] {
+			names[j] = g.constName(num)
+		}
+		edits = append(edits, Edit{
+			Site:     site,
+			NewText:  newErrorContent,
+			Nums:     errNums[i],
+			Names:    names,
+			Renumber: renumber,
+		})
+	}
 
-				// Assign to the return map
-			fileContents[filename] = newContent
+	for num := range g.pinnedNums {
+		g.lastErrNum = max(g.lastErrNum, num)
+	}
+	g.sites, g.siteNums = sites, errNums
+
+	g.summary = Summary{}
+	for _, edit := range edits {
+		if edit.Renumber {
+			g.summary.Renumbered++
+		} else {
+			g.summary.Added += len(edit.Nums)
 		}
+	}
+	g.summary.Skipped = g.wrapped - g.summary.Renumbered
+
+	return edits, contents, errors.Join(errs...)
+}
+
+// Summary returns the counts of the changes made by the last Edits or Generate call
+func (g *Generator) Summary() Summary {
+	return g.summary
+}
 
-		g.lastErrNum += len(errNodes)
+// Generate wraps the errors of the sites with the error numbers and returns the updated file contents
+// together with the output file and the other files rendered by the templates. The sites are expected in the order returned by the parser,
+// the new numbers are assigned in this order.
+// The sites nested in another edited site, e.g. the return of a wrapped function literal, are left unchanged
+// and get no number; the conflicts are returned as errors together with the rest of the updated files.
+func (g *Generator) Generate(sites []errparser.ErrorSite) (fileContents map[string]string, outFilePath string, err error) {
+	// Init updated file contents with the out file
+	fileContents = make(map[string]string)
+
+	// The numbers issued before are never reassigned
+	if err := g.ReadOutputFile(); err != nil {
+		return nil, "", err
+	}
+
+	edits, contents, err := g.edits(sites)
+	if edits == nil {
+		return nil, "", err
+	}
+	// The files that failed to be read are reported, the rest is updated anyway
+	errs := []error{err}
+
+	// Group the edits by file, each file is rewritten in one pass
+	fileEdits := make(map[string][]Edit)
+	for _, edit := range edits {
+		filename := edit.Site.Pos.Filename
+		fileEdits[filename] = append(fileEdits[filename], edit)
+	}
+	for filename, edits := range fileEdits {
+		content, err := applyEdits(contents[filename], edits)
+		if err != nil {
+			errs = append(errs, err)
+		}
 
+		// Import the output package in the updated file
+		content, err = g.fixImports(edits[0].Site.Pkg, filename, content, g.qualifiers[filename])
+		if err != nil {
+			// It's a bug!
+			return nil, "", fmt.Errorf("%s: failed to update the imports: %w", filename, err)
+		}
+		fileContents[filename] = content
 	}
 
-	outFileContent, err := g.genOutputFile()
+	// The registry refers to the lines of the updated files, the templates to the registry
+	g.updated = fileContents
+	if err := g.updateRegistry(); err != nil {
+		return nil, "", err
+	}
+	g.summary.Retired = len(g.retired)
+	outFiles, err := g.genOutputFiles()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to generate the output file: %w", err)
+		return nil, "", fmt.Errorf("failed to generate the output files: %w", err)
 	}
-	fileContents[g.outPathAbs] = outFileContent
+	maps.Copy(fileContents, outFiles)
 
 	return fileContents, g.outPathAbs, errors.Join(errs...)
 }
 
-func (g *Generator) genOutputFile() (string, error) {
-	tmpl, err := template.New("output_file").Parse(string(outputFileTemplate))
-	if err != nil {
-		return "", fmt.Errorf("failed to parse output template: %w", err)
+// collectPinnedNums reserves the numbers pinned with the code directive.
+// Each number can be pinned only once and can't be used by another already wrapped error.
+func (g *Generator) collectPinnedNums(sites []errparser.ErrorSite) error {
+	g.pinnedNums = make(map[int]bool)
+	var errs []error
+	for _, site := range sites {
+		if len(site.Codes) > site.NumErrors() {
+			log.Default().Println(makeErrorMsgf(site.Pkg, site.Node, "too many pinned numbers: %v, expected at most %d",
+				site.Codes, site.NumErrors()))
+		}
+		for _, code := range site.Codes {
+			if g.pinnedNums[code] {
+				errs = append(errs, errors.New(makeErrorMsgf(site.Pkg, site.Node, "error number %d is pinned more than once", code)))
+			}
+			g.pinnedNums[code] = true
+
+			for _, wrapped := range g.wrappedNums {
+				// The pinned site may be already wrapped with its number
+				if wrapped.num != code || wrapped.call == site.Node {
+					continue
+				}
+				errs = append(errs, errors.New(makeErrorMsgf(site.Pkg, site.Node, "error number %d is pinned, but it's already used at %s",
+					code, wrapped.pkg.Fset.Position(wrapped.call.Pos()))))
+			}
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	// Create "const" section
-	// const N_<number> ErrNum = <number>
-	var consts strings.Builder
-	consts.WriteString("const (\n")
+// assignNums returns the error numbers of all errors wrapped by the site:
+// the pinned ones, if given, and the next free numbers for the rest
+func (g *Generator) assignNums(site errparser.ErrorSite) []int {
+	nums := make([]int, site.NumErrors())
+	for i := range nums {
+		if i < len(site.Codes) {
+			nums[i] = site.Codes[i]
+			continue
+		}
+		nums[i] = g.nextErrNum()
+	}
+	return nums
+}
 
-	for i := range g.lastErrNum {
-		line := fmt.Sprintf("\t%s%v ErrNum = %v\n", constErrPrefix, i+1, i+1)
-		consts.WriteString(line)
+// nextErrNum returns the next error number that is not pinned
+func (g *Generator) nextErrNum() int {
+	g.lastErrNum++
+	for g.pinnedNums[g.lastErrNum] {
+		g.lastErrNum++
 	}
-	consts.WriteString(")")
-	vars := map[string]any{
-		"package_name":       g.opts.OutPackageName,
-		"const_declarations": consts.String(),
+	return g.lastErrNum
+}
+
+// rewriteNode returns the new content of the site node, found in the content between the site offsets.
+// Each wrapped error gets the next number from errNums.
+func (g *Generator) rewriteNode(content string, site errparser.ErrorSite, errNums []int) (string, error) {
+	start, end := site.Pos.Offset, site.End.Offset
+	qualifier := g.qualifiers[site.Pos.Filename]
+
+	switch site.Kind {
+	case errparser.SiteBareReturn:
+		// Wrap each named error result before returning, if it's set:
+		// if err != nil {
+		// 	err = errnums.New(errnums.N_12, err)
+		// }
+		// return
+		indent := lineIndent(content, start)
+		var newContent strings.Builder
+		for i, errName := range site.ErrNames {
+			fmt.Fprintf(&newContent, "if %s != nil {\n%s\t%s = %s\n%s}\n%s",
+				errName.Name, indent, errName.Name, g.wrapExpr(qualifier, errName.Name, errNums[i]), indent, indent)
+		}
+		newContent.WriteString(content[start:end])
+		return newContent.String(), checkStmts(newContent.String())
+	case errparser.SiteTupleReturn:
+		// Assign the call results to the temporary variables,
+		// and return them wrapping only the error:
+		// r0, err := loadConfig(path)
+		// return r0, errnums.New(errnums.N_12, err)
+		// Multiple errors are named after their index: err0, err1, ...
+		// The names already in scope are followed by a number, e.g. err2, so nothing is shadowed.
+		indent := lineIndent(content, start)
+		taken := g.scopeNames(site)
+		isErr := make(map[int]bool, len(site.ResultIdxs))
+		for _, errIdx := range site.ResultIdxs {
+			isErr[errIdx] = true
+		}
+		vars := make([]string, site.NumResults)
+		results := make([]string, site.NumResults)
+		for i := range site.NumResults {
+			switch {
+			case !isErr[i]:
+				vars[i] = freshName(taken, fmt.Sprintf("r%d", i))
+			case len(site.ResultIdxs) > 1:
+				vars[i] = freshName(taken, fmt.Sprintf("err%d", i))
+			default:
+				vars[i] = freshName(taken, "err")
+			}
+			results[i] = vars[i]
+		}
+		for i, errIdx := range site.ResultIdxs {
+			results[errIdx] = g.wrapExpr(qualifier, vars[errIdx], errNums[i])
+		}
+		callStart := start + int(site.Call.Pos()-site.Node.Pos())
+		callEnd := start + int(site.Call.End()-site.Node.Pos())
+		newContent := fmt.Sprintf("%s := %s\n%sreturn %s",
+			strings.Join(vars, ", "), content[callStart:callEnd], indent, strings.Join(results, ", "))
+		return newContent, checkStmts(newContent)
+	case errparser.SiteReturn, errparser.SiteDeferredAssign:
+		node, ok := site.Node.(ast.Expr)
+		if !ok {
+			return "", fmt.Errorf("unexpected error node %T", site.Node)
+		}
+		if wrapper, ok := g.asWrapper(site.Pkg, node); ok && len(wrapper.Args) == 2 {
+			// Already wrapped, but the number is pinned: replace just the number
+			// Keep the qualifier of the wrapper
+			if sel, ok := wrapper.Fun.(*ast.SelectorExpr); ok {
+				qualifier = sel.X.(*ast.Ident).Name
+			}
+			numStart := start + int(wrapper.Args[0].Pos()-node.Pos())
+			numEnd := start + int(wrapper.Args[0].End()-node.Pos())
+			newContent := content[start:numStart] + g.numExpr(qualifier, errNums[0]) + content[numEnd:end]
+			_, err := parser.ParseExpr(newContent)
+			return newContent, err
+		}
+
+		// Now wrap the error in the wrapper like:
+		// errnums.New(errnums.N_12, errors.New("original error"))
+		newContent := g.wrapExpr(qualifier, content[start:end], errNums[0])
+		_, err := parser.ParseExpr(newContent)
+		return newContent, err
+	default:
+		return "", fmt.Errorf("unexpected site kind %s", site.Kind)
 	}
+}
+
+// wrapExpr wraps the error expression with the error number,
+// the output package is referred to with the qualifier
+func (g *Generator) wrapExpr(qualifier string, expr string, errNum int) string {
+	return fmt.Sprintf("%s.New(%s, %s)", qualifier, g.numExpr(qualifier, errNum), expr)
+}
+
+// numExpr returns the reference to the error number const
+func (g *Generator) numExpr(qualifier string, errNum int) string {
+	return qualifier + "." + g.constName(errNum)
+}
 
-	var buf bytes.Buffer
-	if err := tmpl.Option("missingkey=invalid").
-		Execute(&buf, vars); err != nil {
-		return "", fmt.Errorf("failed to execute output template: %w", err)
+// lineIndent returns the whitespace the line containing the offset starts with
+func lineIndent(content string, offset int) string {
+	lineStart := strings.LastIndexByte(content[:offset], '\n') + 1
+	line := content[lineStart:offset]
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// scopeNames returns the names that may be in scope at the site: the package level names, the imports of its file
+// and all identifiers of the top level declaration containing it
+func (g *Generator) scopeNames(site errparser.ErrorSite) map[string]bool {
+	names := maps.Clone(g.packageNames(site.Pkg))
+	if names == nil {
+		names = make(map[string]bool)
+	}
+	stxFile := fileOf(site.Pkg, site.Node)
+	if stxFile == nil {
+		return names
+	}
+	for _, imp := range stxFile.Imports {
+		name := importName(site.Pkg, imp)
+		if name == "" {
+			impPath, _ := strconv.Unquote(imp.Path.Value)
+			name = filepath.Base(impPath)
+		}
+		names[name] = true
 	}
+	for _, decl := range stxFile.Decls {
+		if decl.Pos() > site.Node.Pos() || site.Node.End() > decl.End() {
+			continue
+		}
+		ast.Inspect(decl, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				names[ident.Name] = true
+			}
+			return true
+		})
+	}
+	return names
+}
 
-	return buf.String(), err
+// freshName returns the name, or the name followed by the lowest number making it unique, e.g. err2.
+// The returned name is taken.
+func freshName(taken map[string]bool, name string) string {
+	fresh := name
+	for i := 2; taken[fresh]; i++ {
+		fresh = name + strconv.Itoa(i)
+	}
+	taken[fresh] = true
+	return fresh
 }
 
-func getFilename(pkg *packages.Package, position token.Pos) string {
-	tokenFile := pkg.Fset.File(position)
-	filename := tokenFile.Name()
-	return filename
+// checkStmts checks if the statements are syntactically correct
+func checkStmts(stmts string) error {
+	src := "package p\nfunc _() {\n" + stmts + "\n}\n"
+	_, err := parser.ParseFile(token.NewFileSet(), "", src, parser.SkipObjectResolution)
+	return err
 }
@@ -0,0 +1,874 @@
+package generator_test
+
+import (
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anjankow/errnumgen/pkg/errparser"
+	"github.com/anjankow/errnumgen/pkg/generator"
+	"golang.org/x/tools/go/packages"
+)
+
+// generate runs the parser and the generator on the test directory
+func generate(t *testing.T, dir string, outPath string) (map[string]string, string) {
+	t.Helper()
+
+	gopts := generator.GetDefaultGenOptions()
+	gopts.OutPath = outPath
+	return generateWith(t, dir, gopts)
+}
+
+// generateWith runs the parser and the generator with the given options on the test directory
+func generateWith(t *testing.T, dir string, gopts generator.GenOptions) (map[string]string, string) {
+	t.Helper()
+
+	g, parsed := newTestGenerator(t, dir, gopts)
+	updated, outFile, err := g.Generate(parsed)
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	return updated, outFile
+}
+
+// newTestGenerator returns a new generator with the given options and the sites parsed from the test directory.
+// The output file and the skipped paths are not parsed.
+func newTestGenerator(t *testing.T, dir string, gopts generator.GenOptions, skipPaths ...string) (generator.Generator, []errparser.ErrorSite) {
+	t.Helper()
+
+	g, err := generator.New(gopts)
+	if err != nil {
+		t.Fatalf("failed to initialize a new generator: %v", err)
+	}
+
+	popts := errparser.GetDefaultOptions()
+	popts.RetParamParser = g.ParseRetParam
+	popts.FileParser = g.ParseFile
+	popts.SkipPaths = append([]string{gopts.OutPath}, skipPaths...)
+	p, err := errparser.New(dir, popts)
+	if err != nil {
+		t.Fatalf("failed to initialize a new parser: %v", err)
+	}
+	parsed, err := p.Parse()
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	return g, parsed
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := path.Join("./testdata/", t.Name())
+	// The output file is outside of any module, its import path is not known
+	gopts := generator.GetDefaultGenOptions()
+	gopts.OutPath = filepath.Join(t.TempDir(), "errnums", "errnums.go")
+	gopts.DryRun = true
+
+	first, firstOutFile := generateWith(t, dir, gopts)
+	for range 10 {
+		next, nextOutFile := generateWith(t, dir, gopts)
+		if !maps.Equal(first, next) {
+			t.Fatalf("generated contents differ between the runs")
+		}
+		if firstOutFile != nextOutFile {
+			t.Fatalf("output file paths differ between the runs")
+		}
+	}
+
+	// The numbers are assigned by the package path, file name and position
+	expWrapped := map[string][]string{
+		"alpha/first.go": {
+			`errnums.New(errnums.N_1, errors.New("first"))`,
+			`errnums.New(errnums.N_2, errors.New("second"))`,
+		},
+		"alpha/second.go": {
+			`errnums.New(errnums.N_3, fmt.Errorf("third: %d", n))`,
+		},
+		"beta/beta.go": {
+			`errnums.New(errnums.N_4, errSentinel)`,
+			`errnums.New(errnums.N_5, errors.New("fifth"))`,
+		},
+	}
+	for file, wrapped := range expWrapped {
+		filename, err := filepath.Abs(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatalf("failed to get the absolute path: %v", err)
+		}
+		content, ok := first[filename]
+		if !ok {
+			t.Errorf("%s: file not updated", file)
+			continue
+		}
+		for _, w := range wrapped {
+			if !strings.Contains(content, w) {
+				t.Errorf("%s: expected %q in the updated content", file, w)
+			}
+		}
+	}
+
+	if _, err := os.Stat(firstOutFile); err == nil {
+		t.Errorf("the generator should not write the output file")
+	}
+}
+
+func TestGenerateWrapsBareReturns(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+
+	updated, _ := generate(t, dir, outPath)
+	content := updated[filepath.Join(dir, "bare.go")]
+
+	expWrapped := []string{
+		"\t\terr = errors.New(\"negative\")\n" +
+			"\t\tif err != nil {\n" +
+			"\t\t\terr = errnums.New(errnums.N_1, err)\n" +
+			"\t\t}\n" +
+			"\t\treturn\n",
+		"\t}\n" +
+			"\tif err != nil {\n" +
+			"\t\terr = errnums.New(errnums.N_2, err)\n" +
+			"\t}\n" +
+			"\treturn\n",
+		"\t\terr = errnums.New(errnums.N_3, err)\n",
+		"\t\terr = errnums.New(errnums.N_4, err)\n",
+	}
+	for _, w := range expWrapped {
+		if !strings.Contains(content, w) {
+			t.Errorf("expected %q in the updated content:\n%s", w, content)
+		}
+	}
+
+	// Already wrapped bare returns should not be wrapped again
+	writeFiles(t, updated)
+	regenerated, _ := generate(t, dir, outPath)
+	if regenerated[filepath.Join(dir, "bare.go")] != "" {
+		t.Errorf("expected no changes in the wrapped file, got:\n%s", regenerated[filepath.Join(dir, "bare.go")])
+	}
+}
+
+func TestGenerateWrapsTupleReturns(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+
+	updated, _ := generate(t, dir, outPath)
+	content := updated[filepath.Join(dir, "tuple.go")]
+
+	expWrapped := []string{
+		"\tr0, err := loadConfig(path)\n" +
+			"\treturn r0, errnums.New(errnums.N_2, err)\n",
+		// The named results are not shadowed
+		"\t\tr0, err2 := loadConfig(\"default\")\n" +
+			"\t\treturn r0, errnums.New(errnums.N_3, err2)\n",
+		"\tr02, err2 := loadConfig(path)\n" +
+			"\treturn r02, errnums.New(errnums.N_5, err2)\n",
+	}
+	for _, w := range expWrapped {
+		if !strings.Contains(content, w) {
+			t.Errorf("expected %q in the updated content:\n%s", w, content)
+		}
+	}
+
+	// The wrapped returns should not be wrapped again
+	writeFiles(t, updated)
+	checkCompiles(t, dir)
+	regenerated, _ := generate(t, dir, outPath)
+	if regenerated[filepath.Join(dir, "tuple.go")] != "" {
+		t.Errorf("expected no changes in the wrapped file, got:\n%s", regenerated[filepath.Join(dir, "tuple.go")])
+	}
+}
+
+func TestGenerateWrapsMultipleErrors(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+
+	updated, _ := generate(t, dir, outPath)
+	content := updated[filepath.Join(dir, "multi.go")]
+
+	expWrapped := []string{
+		`return errnums.New(errnums.N_1, errors.New("empty")), errnums.New(errnums.N_2, errors.New("invalid"))`,
+		"\t\tif warn != nil {\n" +
+			"\t\t\twarn = errnums.New(errnums.N_3, warn)\n" +
+			"\t\t}\n" +
+			"\t\tif err != nil {\n" +
+			"\t\t\terr = errnums.New(errnums.N_4, err)\n" +
+			"\t\t}\n" +
+			"\t\treturn\n",
+		"\terr0, err1 := validate(s + s)\n" +
+			"\treturn errnums.New(errnums.N_5, err0), errnums.New(errnums.N_6, err1)\n",
+	}
+	for _, w := range expWrapped {
+		if !strings.Contains(content, w) {
+			t.Errorf("expected %q in the updated content:\n%s", w, content)
+		}
+	}
+
+	// The wrapped errors should not be wrapped again
+	writeFiles(t, updated)
+	regenerated, _ := generate(t, dir, outPath)
+	if regenerated[filepath.Join(dir, "multi.go")] != "" {
+		t.Errorf("expected no changes in the wrapped file, got:\n%s", regenerated[filepath.Join(dir, "multi.go")])
+	}
+}
+
+func TestGenerateWrapsDeferredAssigns(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+
+	updated, _ := generate(t, dir, outPath)
+	content := updated[filepath.Join(dir, "deferred.go")]
+
+	// The error assigned to the named result within the deferred function is wrapped in place
+	expWrapped := []string{
+		"\t\treturn errnums.New(errnums.N_1, err)\n",
+		"\t\tif cerr := f.Close(); cerr != nil && err == nil {\n" +
+			"\t\t\terr = errnums.New(errnums.N_2, cerr)\n" +
+			"\t\t}\n",
+		"\treturn errnums.New(errnums.N_3, err)\n",
+	}
+	for _, w := range expWrapped {
+		if !strings.Contains(content, w) {
+			t.Errorf("expected %q in the updated content:\n%s", w, content)
+		}
+	}
+
+	writeFiles(t, updated)
+	checkCompiles(t, dir)
+
+	// The wrapped assignments should not be wrapped again
+	regenerated, _ := generate(t, dir, outPath)
+	if regenerated[filepath.Join(dir, "deferred.go")] != "" {
+		t.Errorf("expected no changes in the wrapped file, got:\n%s", regenerated[filepath.Join(dir, "deferred.go")])
+	}
+}
+
+func TestGenerateManagesImports(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+
+	updated, _ := generate(t, dir, outPath)
+
+	expContent := map[string][]string{
+		"plain.go": {
+			// The output package is imported in its own group
+			"import (\n\t\"errors\"\n\n\t\"example.com/TestGenerateManagesImports/errnums\"\n)\n",
+			"return errnums.New(errnums.N_2, errors.New(\"empty name\"))",
+		},
+		// The parameter named errnums shadows the package name
+		"clash.go": {
+			"errnums2 \"example.com/TestGenerateManagesImports/errnums\"",
+			"return errnums2.New(errnums2.N_1, fmt.Errorf(\"no errors\"))",
+		},
+		// The package level variable of the other file clashes with the package name
+		"sibling/a.go": {
+			"import (\n\t\"errors\"\n\n\terrnums2 \"example.com/TestGenerateManagesImports/errnums\"\n)\n",
+			"return errnums2.New(errnums2.N_3, errors.New(\"a\"))",
+		},
+	}
+	for filename, exp := range expContent {
+		content := updated[filepath.Join(dir, filename)]
+		for _, e := range exp {
+			if !strings.Contains(content, e) {
+				t.Errorf("expected %q in the updated %s:\n%s", e, filename, content)
+			}
+		}
+	}
+
+	writeFiles(t, updated)
+	checkCompiles(t, dir)
+}
+
+func TestGenerateRecognizesWrappers(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+
+	updated, _ := generate(t, dir, outPath)
+
+	expContent := map[string][]string{
+		// The wrapper imported with an alias is not wrapped again
+		"aliased.go": {
+			"return en.New(en.N_4, errors.New(\"wrapped\"))",
+			"return en.New(en.N_5, errors.New(\"unwrapped\"))",
+		},
+		// The local variable named after the output package is not a wrapper.
+		// The import is shadowed, so the output package is imported again with another name.
+		"shadow.go": {
+			"\t\"example.com/TestGenerateRecognizesWrappers/errnums\"\n" +
+				"\terrnums2 \"example.com/TestGenerateRecognizesWrappers/errnums\"\n",
+			"return errnums.New(errnums.N_1, errors.New(\"recorded\"))",
+			"return errnums2.New(errnums2.N_6, err)",
+			"return errnums2.New(errnums2.N_7, errnums.New(1, err))",
+		},
+	}
+	for filename, exp := range expContent {
+		content := updated[filepath.Join(dir, filename)]
+		for _, e := range exp {
+			if !strings.Contains(content, e) {
+				t.Errorf("expected %q in the updated %s:\n%s", e, filename, content)
+			}
+		}
+	}
+
+	writeFiles(t, updated)
+	checkCompiles(t, dir)
+}
+
+func TestGenerateAppliesDirectives(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+
+	updated, outFile := generate(t, dir, outPath)
+	if _, ok := updated[filepath.Join(dir, "ignored.go")]; ok {
+		t.Errorf("the ignored file should not be updated")
+	}
+	content := updated[filepath.Join(dir, "directives.go")]
+
+	// The new numbers start after the highest existing one
+	expContent := []string{
+		`return errnums.New(errnums.N_8, errors.New("first"))`,
+		`return errors.New("ignored")`,
+		`return errors.New("ignored func")`,
+		`return errnums.New(errnums.N_2, errors.New("pinned")) //errnumgen:code 2`,
+		`return errnums.New(errnums.N_42, errSentinel)`,
+		`return errnums.New(errnums.N_9, errSentinel)`,
+	}
+	for _, c := range expContent {
+		if !strings.Contains(content, c) {
+			t.Errorf("expected %q in the updated content:\n%s", c, content)
+		}
+	}
+	if !strings.Contains(updated[outFile], "N_42 ErrNum = 42") {
+		t.Errorf("expected the pinned number to be declared in the output file")
+	}
+
+	writeFiles(t, updated)
+	checkCompiles(t, dir)
+
+	// The pinned numbers are already set
+	regenerated, _ := generate(t, dir, outPath)
+	if regenerated[filepath.Join(dir, "directives.go")] != "" {
+		t.Errorf("expected no changes in the updated file, got:\n%s", regenerated[filepath.Join(dir, "directives.go")])
+	}
+}
+
+func TestGenerateReportsPinnedCollisions(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+
+	gopts := generator.GetDefaultGenOptions()
+	gopts.OutPath = filepath.Join(dir, "errnums", "errnums.go")
+	g, parsed := newTestGenerator(t, dir, gopts)
+
+	// The pinned number is already used by another error
+	_, _, err := g.Generate(parsed)
+	expErr := "error number 1 is pinned, but it's already used at " + filepath.Join(dir, "used.go") + ":10:9"
+	if err == nil || !strings.Contains(err.Error(), expErr) {
+		t.Errorf("expected error %q, got: %v", expErr, err)
+	}
+}
+
+func TestGenerateReportsNestedSites(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+	filename := filepath.Join(dir, "nested.go")
+
+	gopts := generator.GetDefaultGenOptions()
+	gopts.OutPath = outPath
+
+	// The return nested in the wrapped function literal can't be wrapped together with it
+	expErr := filename + ":11 - return is nested in the return at " + filename + ":10:9"
+	g, parsed := newTestGenerator(t, dir, gopts)
+	if _, err := g.Edits(parsed); err == nil || !strings.Contains(err.Error(), expErr) {
+		t.Errorf("expected edits error %q, got: %v", expErr, err)
+	}
+	g, parsed = newTestGenerator(t, dir, gopts)
+	updated, _, err := g.Generate(parsed)
+	if err == nil || !strings.Contains(err.Error(), expErr) {
+		t.Errorf("expected error %q, got: %v", expErr, err)
+	}
+	// The rest is wrapped anyway, the nested one gets no number
+	expWrapped := "return errnums.New(errnums.N_3, errors.New(\"after\"))\n"
+	if !strings.Contains(updated[filename], expWrapped) {
+		t.Errorf("expected %q in the updated content:\n%s", expWrapped, updated[filename])
+	}
+
+	// Once the nested one is ignored, the outer one is wrapped
+	original, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", filename, err)
+	}
+	ignored := strings.Replace(string(original), "\t\treturn err\n", "\t\t//errnumgen:ignore\n\t\treturn err\n", 1)
+	writeFiles(t, map[string]string{filename: ignored})
+	updated, _ = generate(t, dir, outPath)
+	expWrapped = "\treturn errnums.New(errnums.N_2, wrap(func() error {\n" +
+		"\t\t//errnumgen:ignore\n" +
+		"\t\treturn err\n" +
+		"\t}()))\n"
+	if !strings.Contains(updated[filename], expWrapped) {
+		t.Errorf("expected %q in the updated content:\n%s", expWrapped, updated[filename])
+	}
+	writeFiles(t, updated)
+	checkCompiles(t, dir)
+}
+
+func TestGenerateWritesRegistry(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+
+	// generateRegistry runs the generator and returns the updated files with the registry
+	generateRegistry := func() (map[string]string, generator.Registry) {
+		gopts := generator.GetDefaultGenOptions()
+		gopts.OutPath = outPath
+		g, parsed := newTestGenerator(t, dir, gopts)
+		updated, _, err := g.Generate(parsed)
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		content, err := g.Registry()
+		if err != nil {
+			t.Fatalf("failed to update the registry: %v", err)
+		}
+		if g.RegistryPath() != filepath.Join(dir, "errnums", "errnums.json") {
+			t.Errorf("unexpected registry path %s", g.RegistryPath())
+		}
+		updated[g.RegistryPath()] = content
+
+		registry, err := generator.ReadRegistry(func(string) ([]byte, error) { return []byte(content), nil }, g.RegistryPath())
+		if err != nil {
+			t.Fatalf("failed to read the registry: %v", err)
+		}
+		return updated, registry
+	}
+
+	updated, registry := generateRegistry()
+	pkgPath := "example.com/TestGenerateWritesRegistry"
+	expEntries := []generator.RegistryEntry{
+		{Code: 1, Name: "N_1", Line: 26, Function: pkgPath + ".(*Store).Get", Expression: `errors.New("empty key")`},
+		// Not the line of the call of the local variable named like the output package
+		{Code: 2, Name: "N_2", Line: 28, Function: pkgPath + ".(*Store).Get", Expression: `errors.New("not found")`},
+		// The line of the wrapper added before the bare return
+		{Code: 3, Name: "N_3", Line: 34, Function: pkgPath + ".load", Expression: "err"},
+	}
+	if len(registry.Errors) != len(expEntries) {
+		t.Fatalf("expected %d registry entries, found %+v", len(expEntries), registry.Errors)
+	}
+	for i, exp := range expEntries {
+		entry := registry.Errors[i]
+		if entry.FirstAssigned.IsZero() {
+			t.Errorf("entry %d: first assigned time not set", exp.Code)
+		}
+		exp.Package = pkgPath
+		exp.File = "../registry.go"
+		exp.FirstAssigned = entry.FirstAssigned
+		if entry != exp {
+			t.Errorf("expected entry %+v, found %+v", exp, entry)
+		}
+	}
+
+	// The registry is updated incrementally, keeping the first assigned time
+	writeFiles(t, updated)
+	_, updatedRegistry := generateRegistry()
+	if len(updatedRegistry.Errors) != len(registry.Errors) {
+		t.Fatalf("expected %d registry entries, found %+v", len(registry.Errors), updatedRegistry.Errors)
+	}
+	for i, entry := range updatedRegistry.Errors {
+		prev := registry.Errors[i]
+		if !entry.FirstAssigned.Equal(prev.FirstAssigned) {
+			t.Errorf("entry %d: first assigned time changed from %v to %v", entry.Code, prev.FirstAssigned, entry.FirstAssigned)
+		}
+		// The already wrapped numbers are found while parsing, they are not retired
+		if entry.Line != prev.Line || entry.Function != prev.Function || entry.Expression != prev.Expression || entry.Retired {
+			t.Errorf("entry %d: expected the same location %+v, found %+v", entry.Code, prev, entry)
+		}
+	}
+}
+
+func TestGenerateRetiresNumbers(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+
+	updated, outFile := generate(t, dir, outPath)
+
+	// The number removed from the source is not reassigned
+	content := updated[filepath.Join(dir, "retire.go")]
+	expWrapped := "return errnums.New(errnums.N_4, errors.New(\"added\"))"
+	if !strings.Contains(content, expWrapped) {
+		t.Errorf("expected %q in the updated content:\n%s", expWrapped, content)
+	}
+
+	// It's kept in the output file, marked as deprecated
+	expConsts := "\tN_2 ErrNum = 2\n" +
+		"\t// Deprecated: N_3 is not used in the source anymore, it's retired and never reassigned.\n" +
+		"\tN_3 ErrNum = 3\n" +
+		"\tN_4 ErrNum = 4\n"
+	if !strings.Contains(updated[outFile], expConsts) {
+		t.Errorf("expected %q in the output file:\n%s", expConsts, updated[outFile])
+	}
+
+	writeFiles(t, updated)
+	checkCompiles(t, dir)
+
+	// The retired number stays retired
+	gopts := generator.GetDefaultGenOptions()
+	gopts.OutPath = outPath
+	g, parsed := newTestGenerator(t, dir, gopts)
+	regenerated, outFile, err := g.Generate(parsed)
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	if regenerated[outFile] != updated[outFile] {
+		t.Errorf("expected the same output file, got:\n%s", regenerated[outFile])
+	}
+	registry, err := g.Registry()
+	if err != nil {
+		t.Fatalf("failed to update the registry: %v", err)
+	}
+	writeFiles(t, map[string]string{g.RegistryPath(): registry})
+
+	// The registry keeps the issued numbers when the output file is removed,
+	// even the highest one, not used in the source anymore
+	if err := os.Remove(outFile); err != nil {
+		t.Fatalf("failed to remove the output file: %v", err)
+	}
+	filename := filepath.Join(dir, "retire.go")
+	content = strings.Replace(content, expWrapped, "return errors.New(\"another\")", 1)
+	writeFiles(t, map[string]string{filename: content})
+	regenerated, outFile = generate(t, dir, outPath)
+	expWrapped = "return errnums.New(errnums.N_5, errors.New(\"another\"))"
+	if !strings.Contains(regenerated[filename], expWrapped) {
+		t.Errorf("expected %q in the regenerated content:\n%s", expWrapped, regenerated[filename])
+	}
+	expConsts = "\t// Deprecated: N_3 is not used in the source anymore, it's retired and never reassigned.\n" +
+		"\tN_3 ErrNum = 3\n" +
+		"\t// Deprecated: N_4 is not used in the source anymore, it's retired and never reassigned.\n" +
+		"\tN_4 ErrNum = 4\n" +
+		"\tN_5 ErrNum = 5\n"
+	if !strings.Contains(regenerated[outFile], expConsts) {
+		t.Errorf("expected %q in the regenerated output file:\n%s", expConsts, regenerated[outFile])
+	}
+}
+
+func TestGenerateKeepsNumbersOfSkippedFiles(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+
+	// generateSkipping runs the generator skipping the given paths and returns the updated files with the registry
+	generateSkipping := func(skipPaths ...string) (map[string]string, string, generator.Registry) {
+		gopts := generator.GetDefaultGenOptions()
+		gopts.OutPath = outPath
+		g, parsed := newTestGenerator(t, dir, gopts, skipPaths...)
+		updated, outFile, err := g.Generate(parsed)
+		if err != nil {
+			t.Fatalf("failed to generate: %v", err)
+		}
+		content, err := g.Registry()
+		if err != nil {
+			t.Fatalf("failed to update the registry: %v", err)
+		}
+		updated[g.RegistryPath()] = content
+		registry, err := generator.ReadRegistry(func(string) ([]byte, error) { return []byte(content), nil }, g.RegistryPath())
+		if err != nil {
+			t.Fatalf("failed to read the registry: %v", err)
+		}
+		return updated, outFile, registry
+	}
+
+	updated, _, _ := generateSkipping()
+	writeFiles(t, updated)
+
+	// N_1 is removed from a.go, N_2 is still used in b.go, which is skipped.
+	// N_3 is used within the ignored function, it's not parsed as an error.
+	filename := filepath.Join(dir, "a.go")
+	content := "package partial\n\n" +
+		"import \"example.com/TestGenerateKeepsNumbersOfSkippedFiles/errnums\"\n\n" +
+		"func a() {}\n\n" +
+		"//errnumgen:ignore\n" +
+		"func c(err error) error {\n\treturn errnums.New(errnums.N_3, err)\n}\n"
+	if err := os.WriteFile(filename, []byte(content), 0664); err != nil {
+		t.Fatalf("failed to write %s: %v", filename, err)
+	}
+	updated, outFile, registry := generateSkipping(filepath.Join(dir, "b.go"))
+
+	expConsts := "\t// Deprecated: N_1 is not used in the source anymore, it's retired and never reassigned.\n" +
+		"\tN_1 ErrNum = 1\n" +
+		"\tN_2 ErrNum = 2\n" +
+		"\tN_3 ErrNum = 3\n" +
+		")\n"
+	if !strings.Contains(updated[outFile], expConsts) {
+		t.Errorf("expected %q in the output file:\n%s", expConsts, updated[outFile])
+	}
+	for code, expRetired := range map[int]bool{1: true, 2: false} {
+		entry, ok := registry.Lookup(code)
+		if !ok {
+			t.Fatalf("entry %d not found in the registry %+v", code, registry.Errors)
+		}
+		if entry.Retired != expRetired {
+			t.Errorf("entry %d: expected retired %v, found %+v", code, expRetired, entry)
+		}
+		// The last known location is kept
+		if expFile := string(rune('a'+code-1)) + ".go"; entry.File != "../"+expFile {
+			t.Errorf("entry %d: expected file ../%s, found %+v", code, expFile, entry)
+		}
+	}
+}
+
+func TestGenerateRefusesOtherConstPrefix(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+
+	updated, _ := generate(t, dir, outPath)
+	writeFiles(t, updated)
+
+	// The numbers generated as N_1 are not recognized with another prefix
+	gopts := generator.GetDefaultGenOptions()
+	gopts.OutPath = outPath
+	gopts.ConstPrefix = "ERR"
+	g, parsed := newTestGenerator(t, dir, gopts)
+	_, _, err := g.Generate(parsed)
+	expErr := `the output file declares the error number N_1, not with the const prefix "ERR"`
+	if err == nil || !strings.Contains(err.Error(), expErr) {
+		t.Errorf("expected error %q, got: %v", expErr, err)
+	}
+}
+
+func TestGenerateFormatsCodes(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+
+	gopts := generator.GetDefaultGenOptions()
+	gopts.OutPath = filepath.Join(dir, "errnums", "errnums.go")
+	gopts.ConstPrefix = "E"
+	gopts.CodeWidth = 4
+	gopts.NumPrefix = "E"
+	gopts.CodeSeparator = "."
+	gopts.CodePrefix = "PAY-"
+	updated, outFile := generateWith(t, dir, gopts)
+
+	content := updated[filepath.Join(dir, "format.go")]
+	expWrapped := "return errnums.New(errnums.E1, errors.New(\"declined\"))"
+	if !strings.Contains(content, expWrapped) {
+		t.Errorf("expected %q in the updated content:\n%s", expWrapped, content)
+	}
+	expOut := []string{
+		"\tE1 ErrNum = 1\n",
+		"\tcodePrefix    = \"PAY-\"\n" +
+			"\tcodeSeparator = \".\"\n" +
+			"\tnumPrefix     = \"E\"\n" +
+			"\tnumWidth      = 4\n",
+	}
+	for _, e := range expOut {
+		if !strings.Contains(updated[outFile], e) {
+			t.Errorf("expected %q in the output file:\n%s", e, updated[outFile])
+		}
+	}
+
+	writeFiles(t, updated)
+	checkCompiles(t, dir)
+
+	// The numbers wrapped with the custom prefix are recognized
+	regenerated, _ := generateWith(t, dir, gopts)
+	if regenerated[filepath.Join(dir, "format.go")] != "" {
+		t.Errorf("expected no changes in the wrapped file, got:\n%s", regenerated[filepath.Join(dir, "format.go")])
+	}
+}
+
+func TestGenerateUsesTemplates(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outDir := filepath.Join(dir, "errnums")
+
+	gopts := generator.GetDefaultGenOptions()
+	gopts.OutPath = filepath.Join(outDir, "errnums.go")
+	gopts.Template = filepath.Join(dir, "templates")
+	gopts.CodeWidth = 3
+	gopts.NumPrefix = "E"
+	updated, outFile := generateWith(t, dir, gopts)
+
+	// Each template renders its file, except the ones starting with _
+	if _, ok := updated[filepath.Join(outDir, "_header")]; ok {
+		t.Errorf("unexpected file rendered from _header.tmpl")
+	}
+	expOut := map[string][]string{
+		outFile: {
+			"// Code generated by errnumgen ",
+			"\tN_1 ErrNum = 1\n\tN_2 ErrNum = 2\n",
+			`fmt.Sprintf("%s%0*d", "E", 3, int(n))`,
+		},
+		filepath.Join(outDir, "codes.go"): {
+			"package errnums\n",
+			`"E001": "TestGenerateUsesTemplates.Get errors.New(\"empty key\")",`,
+			`"E002": "TestGenerateUsesTemplates.Get errors.New(\"not found\")",`,
+		},
+	}
+	for filename, exp := range expOut {
+		for _, e := range exp {
+			if !strings.Contains(updated[filename], e) {
+				t.Errorf("expected %q in %s:\n%s", e, filename, updated[filename])
+			}
+		}
+	}
+
+	writeFiles(t, updated)
+	checkCompiles(t, dir)
+
+	// One of the templates must render the output file
+	gopts.OutPath = filepath.Join(outDir, "nums.go")
+	if _, err := generator.New(gopts); err == nil {
+		t.Errorf("expected an error for the templates not rendering the output file")
+	}
+}
+
+func TestEditsListsChangedSites(t *testing.T) {
+	log.SetOutput(io.Discard)
+	dir := copyModule(t, path.Join("./testdata/", t.Name()))
+	outPath := filepath.Join(dir, "errnums", "errnums.go")
+
+	gopts := generator.GetDefaultGenOptions()
+	gopts.OutPath = outPath
+	g, parsed := newTestGenerator(t, dir, gopts)
+
+	edits, err := g.Edits(parsed)
+	if err != nil {
+		t.Fatalf("failed to get the edits: %v", err)
+	}
+
+	// The already wrapped site is not listed
+	filename := filepath.Join(dir, "check.go")
+	expEdits := []string{
+		filename + ":16:9: wrap return of sentinel error with N_3",
+		filename + ":21:9: renumber return of call error with N_5",
+	}
+	if len(edits) != len(expEdits) {
+		t.Fatalf("expected %d edits, found %v", len(expEdits), edits)
+	}
+	for i, edit := range edits {
+		if edit.String() != expEdits[i] {
+			t.Errorf("expected edit %q, found %q", expEdits[i], edit.String())
+		}
+	}
+	// The already wrapped site with the correct number is skipped.
+	// The retired numbers are counted only by Generate.
+	expSummary := generator.Summary{Added: 1, Renumbered: 1, Skipped: 1}
+	if g.Summary() != expSummary {
+		t.Errorf("expected summary %+v, found %+v", expSummary, g.Summary())
+	}
+
+	// N_2 is replaced with the pinned number and N_4 is not used, both are retired
+	updated, outFile := generate(t, dir, outPath)
+	for _, name := range []string{"N_2", "N_4"} {
+		expDeprecated := "\t// Deprecated: " + name + " is not used in the source anymore"
+		if !strings.Contains(updated[outFile], expDeprecated) {
+			t.Errorf("expected %q in the output file:\n%s", expDeprecated, updated[outFile])
+		}
+	}
+}
+
+func TestNewRequiresOutImportPath(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	// The output file is outside of any module, the updated files couldn't import it
+	gopts := generator.GetDefaultGenOptions()
+	gopts.OutPath = filepath.Join(t.TempDir(), "errnums", "errnums.go")
+	if _, err := generator.New(gopts); err == nil {
+		t.Errorf("expected an error for the unknown import path of the output package")
+	}
+
+	dryRun := gopts
+	dryRun.DryRun = true
+	if _, err := generator.New(dryRun); err != nil {
+		t.Errorf("expected no error for the dry run, got: %v", err)
+	}
+
+	explicit := gopts
+	explicit.OutImportPath = "example.com/app/errnums"
+	if _, err := generator.New(explicit); err != nil {
+		t.Errorf("expected no error for the explicit import path, got: %v", err)
+	}
+}
+
+func TestNewValidatesCodeFormat(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	invalid := []func(*generator.GenOptions){
+		func(o *generator.GenOptions) { o.ConstPrefix = "n_" },
+		func(o *generator.GenOptions) { o.ConstPrefix = "N-" },
+		func(o *generator.GenOptions) { o.ConstPrefix = "N1" },
+		func(o *generator.GenOptions) { o.CodeWidth = -1 },
+		func(o *generator.GenOptions) { o.CodeSeparator = "0" },
+		func(o *generator.GenOptions) { o.NumPrefix = "E-" },
+	}
+	for i, set := range invalid {
+		gopts := generator.GetDefaultGenOptions()
+		set(&gopts)
+		if _, err := generator.New(gopts); err == nil {
+			t.Errorf("%d: expected an error for the options %+v", i, gopts)
+		}
+	}
+
+	gopts := generator.GetDefaultGenOptions()
+	gopts.ConstPrefix = "Err"
+	if _, err := generator.New(gopts); err != nil {
+		t.Errorf("expected the valid options, got: %v", err)
+	}
+}
+
+// checkCompiles checks if the packages of the module compile without errors
+func checkCompiles(t *testing.T, dir string) {
+	t.Helper()
+
+	cfg := &packages.Config{Mode: packages.LoadAllSyntax, Dir: dir}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		t.Fatalf("failed to load the module: %v", err)
+	}
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, err := range pkg.Errors {
+			t.Errorf("package %s has errors: %v", pkg.PkgPath, err)
+		}
+	})
+}
+
+// copyModule copies the test directory to a new module in a temporary directory
+func copyModule(t *testing.T, src string) string {
+	t.Helper()
+
+	dst := t.TempDir()
+	if err := os.CopyFS(dst, os.DirFS(src)); err != nil {
+		t.Fatalf("failed to copy the test directory: %v", err)
+	}
+	goMod := "module example.com/" + filepath.Base(src) + "\n\ngo 1.25\n"
+	if err := os.WriteFile(filepath.Join(dst, "go.mod"), []byte(goMod), 0664); err != nil {
+		t.Fatalf("failed to write go.mod: %v", err)
+	}
+	return dst
+}
+
+// writeFiles writes the generated contents to the disk
+func writeFiles(t *testing.T, contents map[string]string) {
+	t.Helper()
+
+	for filename, content := range contents {
+		if err := os.MkdirAll(filepath.Dir(filename), 0775); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0664); err != nil {
+			t.Fatalf("failed to write %s: %v", filename, err)
+		}
+	}
+}
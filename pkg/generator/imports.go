@@ -0,0 +1,466 @@
+package generator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// findOutImportPath returns the import path of the output package,
+// found from the module path of the closest go.mod file
+func findOutImportPath(outPathAbs string) (string, error) {
+	return ImportPath(filepath.Dir(outPathAbs))
+}
+
+// ImportPath returns the import path of the package in the directory,
+// found from the module path of the closest go.mod file
+func ImportPath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for modDir := dir; ; modDir = filepath.Dir(modDir) {
+		data, err := os.ReadFile(filepath.Join(modDir, "go.mod"))
+		if errors.Is(err, fs.ErrNotExist) {
+			if parent := filepath.Dir(modDir); parent == modDir {
+				return "", fmt.Errorf("go.mod not found for %s", dir)
+			}
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read go.mod: %w", err)
+		}
+
+		modPath := modfile.ModulePath(data)
+		if modPath == "" {
+			return "", fmt.Errorf("module path not found in %s", filepath.Join(modDir, "go.mod"))
+		}
+		rel, err := filepath.Rel(modDir, dir)
+		if err != nil {
+			return "", err
+		}
+		return path.Join(modPath, filepath.ToSlash(rel)), nil
+	}
+}
+
+// outQualifier returns the name the output package is referred to within the file.
+// It's the name of the existing import, if the file imports the output package already
+// and the name is not shadowed anywhere within the file.
+// Otherwise it's the output package name, unless it clashes with another identifier:
+// then the name is followed by the lowest number making it unique, e.g. errnums2.
+func (g *Generator) outQualifier(pkg *packages.Package, filename string, content string) string {
+	// The object resolution finds the identifiers declared within the file
+	stxFile, err := parser.ParseFile(token.NewFileSet(), filename, content, 0)
+	if err != nil {
+		return g.opts.OutPackageName
+	}
+
+	taken := make(map[string]bool)
+	ast.Inspect(stxFile, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && ident.Obj != nil {
+			taken[ident.Name] = true
+		}
+		return true
+	})
+	for _, imp := range stxFile.Imports {
+		impPath, _ := strconv.Unquote(imp.Path.Value)
+		name := importName(pkg, imp)
+		if impPath == g.outImportPath && g.outImportPath != "" {
+			if name == "" {
+				name = g.opts.OutPackageName
+			}
+			if !taken[name] {
+				return name
+			}
+			// Shadowed by a local declaration, the output package is imported again with another name
+			continue
+		}
+		if name == "" {
+			// Most likely the last element of the import path
+			name = path.Base(impPath)
+		}
+		taken[name] = true
+	}
+	isTaken := func(name string) bool {
+		// The package level declarations of the other files clash with the import too
+		return taken[name] || stxFile.Scope.Lookup(name) != nil || g.packageNames(pkg)[name]
+	}
+
+	name := g.opts.OutPackageName
+	for i := 2; isTaken(name); i++ {
+		name = g.opts.OutPackageName + strconv.Itoa(i)
+	}
+	return name
+}
+
+// packageNames returns the names declared in the package block by all files of the package.
+// Without the type information the files are read again, as the parser doesn't keep the declarations
+// of all of them, e.g. of the skipped files or of the ones parsed only for their package clause.
+// The result is cached.
+func (g *Generator) packageNames(pkg *packages.Package) map[string]bool {
+	if pkg == nil {
+		return nil
+	}
+	if names, ok := g.pkgNames[pkg.ID]; ok {
+		return names
+	}
+	names := make(map[string]bool)
+	g.pkgNames[pkg.ID] = names
+	if pkg.Types != nil {
+		for _, name := range pkg.Types.Scope().Names() {
+			names[name] = true
+		}
+		return names
+	}
+
+	for _, filename := range pkg.GoFiles {
+		content, err := g.readFile(filename)
+		if err != nil {
+			continue
+		}
+		stxFile, err := parser.ParseFile(token.NewFileSet(), filename, content, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		for _, decl := range stxFile.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					names[decl.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							names[name.Name] = true
+						}
+					case *ast.TypeSpec:
+						names[spec.Name.Name] = true
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// importName returns the name the imported package is referred to within the file;
+// empty if it's not known
+func importName(pkg *packages.Package, imp *ast.ImportSpec) string {
+	if imp.Name != nil {
+		return imp.Name.Name
+	}
+	impPath, _ := strconv.Unquote(imp.Path.Value)
+	if pkg != nil {
+		if imported, ok := pkg.Imports[impPath]; ok && imported.Name != "" {
+			return imported.Name
+		}
+	}
+	return ""
+}
+
+// isOutPackage reports whether the identifier refers to the output package.
+// With the type information the identifier is resolved, otherwise it's matched
+// against the imports of its file, unless it's declared within the file, see declaredLocally.
+// If the output import path is not known, the identifier is expected to be named after the output package.
+func (g *Generator) isOutPackage(pkg *packages.Package, ident *ast.Ident) bool {
+	if pkg != nil && pkg.TypesInfo != nil {
+		if pkgName, ok := pkg.TypesInfo.Uses[ident].(*types.PkgName); ok {
+			if g.outImportPath == "" {
+				return pkgName.Imported().Name() == g.opts.OutPackageName
+			}
+			return pkgName.Imported().Path() == g.outImportPath
+		}
+		if _, ok := pkg.TypesInfo.Uses[ident]; ok {
+			return false
+		}
+	}
+	if g.declaredLocally(pkg, ident) {
+		// e.g. a local variable named errnums
+		return false
+	}
+	return g.importsOutPackage(pkg, fileOf(pkg, ident), ident)
+}
+
+// importsOutPackage reports whether the identifier is the name the file imports the output package with.
+// The identifier is not resolved, see isOutPackage.
+func (g *Generator) importsOutPackage(pkg *packages.Package, stxFile *ast.File, ident *ast.Ident) bool {
+	if g.outImportPath == "" {
+		return ident.Name == g.opts.OutPackageName
+	}
+	if stxFile == nil {
+		return false
+	}
+	for _, imp := range stxFile.Imports {
+		impPath, _ := strconv.Unquote(imp.Path.Value)
+		if impPath != g.outImportPath {
+			continue
+		}
+		name := importName(pkg, imp)
+		if name == "" {
+			name = g.opts.OutPackageName
+		}
+		if name == ident.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// declaredLocally reports whether the identifier refers to a declaration within its file,
+// e.g. a local variable shadowing the import. The parser skips the object resolution,
+// so the file is parsed again to resolve its identifiers; the result is cached.
+func (g *Generator) declaredLocally(pkg *packages.Package, ident *ast.Ident) bool {
+	if ident.Obj != nil {
+		return true
+	}
+	if pkg == nil || pkg.Fset == nil || pkg.Fset.File(ident.Pos()) == nil {
+		return false
+	}
+	pos := pkg.Fset.PositionFor(ident.Pos(), false)
+	locals, ok := g.localIdents[pos.Filename]
+	if !ok {
+		locals = g.resolveLocals(pos.Filename)
+		g.localIdents[pos.Filename] = locals
+	}
+	return locals[pos.Offset]
+}
+
+// resolveLocals returns the offsets of the identifiers declared within the file or referring to such declarations
+func (g *Generator) resolveLocals(filename string) map[int]bool {
+	content, err := g.readFile(filename)
+	if err != nil {
+		return nil
+	}
+	fset := token.NewFileSet()
+	stxFile, err := parser.ParseFile(fset, filename, content, 0)
+	if err != nil {
+		return nil
+	}
+	locals := make(map[int]bool)
+	ast.Inspect(stxFile, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && ident.Obj != nil {
+			locals[fset.Position(ident.Pos()).Offset] = true
+		}
+		return true
+	})
+	return locals
+}
+
+// fileOf returns the syntax tree of the package file containing the node
+func fileOf(pkg *packages.Package, node ast.Node) *ast.File {
+	if pkg == nil {
+		return nil
+	}
+	for _, stxFile := range pkg.Syntax {
+		if stxFile.FileStart <= node.Pos() && node.Pos() < stxFile.FileEnd {
+			return stxFile
+		}
+	}
+	return nil
+}
+
+// fixImports adds the import of the output package, if it's referred to with the qualifier,
+// removes the unused imports and formats the file content.
+// The imports are removed only if their names are known: aliased or with the type information loaded.
+func (g *Generator) fixImports(pkg *packages.Package, filename string, content string, qualifier string) (string, error) {
+	fset := token.NewFileSet()
+	stxFile, err := parser.ParseFile(fset, filename, content, parser.ParseComments)
+	if err != nil {
+		return "", err
+	}
+
+	addedSpec := ""
+	if usesName(stxFile, qualifier) {
+		addedSpec = g.addImport(fset, stxFile, qualifier)
+	}
+
+	var unused []*ast.ImportSpec
+	for _, imp := range stxFile.Imports {
+		name := importName(pkg, imp)
+		impPath, _ := strconv.Unquote(imp.Path.Value)
+		if impPath == g.outImportPath && name == "" {
+			name = qualifier
+		}
+		if name == "" || name == "_" || name == "." || usesName(stxFile, name) {
+			continue
+		}
+		unused = append(unused, imp)
+	}
+	for _, imp := range unused {
+		impPath, _ := strconv.Unquote(imp.Path.Value)
+		if imp.Name != nil {
+			astutil.DeleteNamedImport(fset, stxFile, imp.Name.Name, impPath)
+		} else {
+			astutil.DeleteImport(fset, stxFile, impPath)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, stxFile); err != nil {
+		return "", err
+	}
+	if addedSpec != "" {
+		return separateImport(buf.String(), addedSpec), nil
+	}
+	return buf.String(), nil
+}
+
+// addImport adds the import of the output package referred to with the qualifier to the file.
+// It returns the added import spec, e.g. errnums2 "example.com/errnums", empty if it's already imported
+// or its import path is not known.
+func (g *Generator) addImport(fset *token.FileSet, stxFile *ast.File, qualifier string) string {
+	if g.outImportPath == "" {
+		return ""
+	}
+	alias := qualifier
+	if alias == path.Base(g.outImportPath) {
+		alias = ""
+	}
+	if !astutil.AddNamedImport(fset, stxFile, alias, g.outImportPath) {
+		return ""
+	}
+	return strings.TrimSpace(alias + " " + strconv.Quote(g.outImportPath))
+}
+
+// ImportEdit replaces the import declarations of a file to add the import of the output package
+type ImportEdit struct {
+	// Offset and End are the offsets of the replaced content within the original file content:
+	// from the end of the package clause to the end of the last import declaration
+	Offset, End int
+	// NewText are the import declarations with the output package import added, formatted
+	NewText string
+}
+
+// ImportEdit returns the edit adding the import of the output package to the file, the way Generate adds it,
+// so that the file compiles with the edits returned by Edits applied. It's not found if the file
+// already imports the output package with the name the edits refer to it with, or if Edits didn't edit the file.
+// Unlike Generate, it doesn't remove the imports left unused.
+func (g *Generator) ImportEdit(filename string) (edit ImportEdit, found bool, err error) {
+	qualifier, ok := g.qualifiers[filename]
+	if !ok {
+		return ImportEdit{}, false, nil
+	}
+	content, err := g.readFile(filename)
+	if err != nil {
+		return ImportEdit{}, false, fmt.Errorf("%s: failed to read: %w", filename, err)
+	}
+	fset := token.NewFileSet()
+	stxFile, err := parser.ParseFile(fset, filename, content, parser.ParseComments)
+	if err != nil {
+		return ImportEdit{}, false, fmt.Errorf("%s: failed to parse: %w", filename, err)
+	}
+	edit.Offset, edit.End = importsRange(fset, stxFile)
+
+	spec := g.addImport(fset, stxFile, qualifier)
+	if spec == "" {
+		return ImportEdit{}, false, nil
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, stxFile); err != nil {
+		return ImportEdit{}, false, err
+	}
+	newContent := separateImport(buf.String(), spec)
+
+	// The updated file is parsed again, the formatting may have moved the declarations
+	fset = token.NewFileSet()
+	stxFile, err = parser.ParseFile(fset, filename, newContent, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		// It's a bug!
+		return ImportEdit{}, false, fmt.Errorf("%s: failed to parse the added import: %w", filename, err)
+	}
+	start, end := importsRange(fset, stxFile)
+	edit.NewText = newContent[start:end]
+	return edit, true, nil
+}
+
+// importsRange returns the offsets from the end of the package clause to the end of the last import declaration,
+// both at the end of the package clause if the file imports nothing
+func importsRange(fset *token.FileSet, stxFile *ast.File) (int, int) {
+	end := stxFile.Name.End()
+	for _, decl := range stxFile.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			break
+		}
+		end = genDecl.End()
+	}
+	return fset.Position(stxFile.Name.End()).Offset, fset.Position(end).Offset
+}
+
+// separateImport separates the added import spec with a blank line from the neighboring imports of another group,
+// so the standard library imports and the others are grouped the way goimports does it.
+// The import added by astutil goes next to the import of the most similar path, e.g. into the standard library
+// group if there's no other.
+func separateImport(content string, spec string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if line != "\t"+spec {
+			continue
+		}
+		group := isStdImport(spec)
+		var separated []string
+		separated = append(separated, lines[:i]...)
+		if prev, ok := importLine(lines[i-1]); ok && isStdImport(prev) != group {
+			separated = append(separated, "")
+		}
+		separated = append(separated, line)
+		if next, ok := importLine(lines[i+1]); ok && isStdImport(next) != group {
+			separated = append(separated, "")
+		}
+		separated = append(separated, lines[i+1:]...)
+		return strings.Join(separated, "\n")
+	}
+	return content
+}
+
+// importLine returns the import spec of the line within the import block, e.g. errnums2 "example.com/errnums"
+func importLine(line string) (string, bool) {
+	spec, ok := strings.CutPrefix(line, "\t")
+	if !ok || !strings.Contains(spec, `"`) {
+		return "", false
+	}
+	return spec, true
+}
+
+// isStdImport reports whether the import spec imports a standard library package:
+// the first element of its path has no dot, as goimports decides it
+func isStdImport(spec string) bool {
+	_, quoted, _ := strings.Cut(spec, `"`)
+	impPath, _, _ := strings.Cut(quoted, `"`)
+	firstElem, _, _ := strings.Cut(impPath, "/")
+	return !strings.Contains(firstElem, ".")
+}
+
+// usesName reports whether the name is used as the package qualifier within the file,
+// e.g. errnums.New
+func usesName(stxFile *ast.File, name string) bool {
+	used := false
+	ast.Inspect(stxFile, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok || used {
+			return !used
+		}
+		if ident, ok := sel.X.(*ast.Ident); ok && ident.Name == name && ident.Obj == nil {
+			used = true
+		}
+		return !used
+	})
+	return used
+}
@@ -0,0 +1,286 @@
+package generator
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/anjankow/errnumgen/pkg/errparser"
+	"golang.org/x/tools/go/packages"
+)
+
+// Registry records where each error number is used, so the numbers can be mapped back to the code.
+// It's written next to the output file as JSON and updated on each run.
+type Registry struct {
+	Errors []RegistryEntry `json:"errors"`
+}
+
+// RegistryEntry describes the error wrapped with the number
+type RegistryEntry struct {
+	// Code is the error number
+	Code int `json:"code"`
+	// Name is the name of the error number const, e.g. N_12
+	Name string `json:"name"`
+	// Package is the import path of the package the error is returned from
+	Package string `json:"package"`
+	// File is the slash separated path of the file, relative to the registry
+	File string `json:"file"`
+	Line int    `json:"line"`
+	// Function is the qualified name of the function returning the error, e.g. example.com/pkg.(*Decoder).Decode
+	Function string `json:"function"`
+	// Expression is the source of the wrapped error expression, e.g. errors.New("empty name")
+	Expression string `json:"expression"`
+	// FirstAssigned is the time the number was first recorded
+	FirstAssigned time.Time `json:"first_assigned"`
+	// Retired is set if the number is not used in the source anymore,
+	// the entry holds its last known location
+	Retired bool `json:"retired,omitempty"`
+}
+
+// Lookup returns the entry of the error number
+func (r Registry) Lookup(code int) (RegistryEntry, bool) {
+	for _, entry := range r.Errors {
+		if entry.Code == code {
+			return entry, true
+		}
+	}
+	return RegistryEntry{}, false
+}
+
+// ReadRegistry reads the registry from the file; it's empty if the file doesn't exist
+func ReadRegistry(readFile ReadFileFunc, filename string) (Registry, error) {
+	data, err := readFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Registry{}, nil
+	}
+	if err != nil {
+		return Registry{}, fmt.Errorf("failed to read the registry: %w", err)
+	}
+
+	var registry Registry
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return Registry{}, fmt.Errorf("failed to decode the registry %s: %w", filename, err)
+	}
+	return registry, nil
+}
+
+// RegistryPath returns the path of the registry, the output file with the .json extension
+func (g *Generator) RegistryPath() string {
+	return strings.TrimSuffix(g.outPathAbs, ".go") + ".json"
+}
+
+// Registry returns the content of the registry updated by the last Generate call with its sites
+// and the already wrapped errors found while parsing.
+// The location of each found number is updated, the first assigned time is kept.
+// The numbers not found anymore keep their last known location and are marked as retired.
+func (g *Generator) Registry() (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(g.registry); err != nil {
+		return "", fmt.Errorf("failed to encode the registry: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// updateRegistry updates the registry read from its file with the sites and the wrapped numbers,
+// and finds the retired numbers: the ones not used anymore, unless their last known file is not parsed
+func (g *Generator) updateRegistry() error {
+	registryPath := g.RegistryPath()
+	registry, err := ReadRegistry(g.readFile, registryPath)
+	if err != nil {
+		return err
+	}
+	entries := make(map[int]RegistryEntry, len(registry.Errors))
+	for _, entry := range registry.Errors {
+		entries[entry.Code] = entry
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	found := make(map[int]bool)
+	// The lines of the numbers within the files updated by Generate
+	updatedLines := make(map[string]map[int]int)
+	record := func(pkg *packages.Package, pos token.Position, funcName string, num int, expr string) {
+		if found[num] {
+			// Already recorded by an earlier site
+			return
+		}
+		found[num] = true
+
+		entry, ok := entries[num]
+		if !ok {
+			entry.FirstAssigned = now
+		}
+		filename, err := filepath.Rel(filepath.Dir(registryPath), pos.Filename)
+		if err != nil {
+			filename = pos.Filename
+		}
+		entry.Code = num
+		entry.Name = g.constName(num)
+		entry.Package = pkg.PkgPath
+		entry.File = filepath.ToSlash(filename)
+		entry.Line = pos.Line
+		if content, ok := g.updated[pos.Filename]; ok {
+			lines, ok := updatedLines[pos.Filename]
+			if !ok {
+				lines = g.wrapperLines(pkg, pos.Filename, content)
+				updatedLines[pos.Filename] = lines
+			}
+			if line, ok := lines[num]; ok {
+				entry.Line = line
+			}
+		}
+		entry.Function = funcName
+		entry.Expression = expr
+		entry.Retired = false
+		entries[num] = entry
+	}
+
+	siteNodes := make(map[ast.Node]bool, len(g.sites))
+	for i, site := range g.sites {
+		siteNodes[site.Node] = true
+		for j, num := range g.siteNums[i] {
+			record(site.Pkg, site.Pos, site.FuncName, num, g.wrappedExpr(site, j))
+		}
+	}
+	// The already wrapped errors, unless renumbered as the sites
+	srcs := make(map[string][]byte)
+	for _, wrapped := range g.wrappedNums {
+		if siteNodes[wrapped.call] {
+			continue
+		}
+		pos := wrapped.pkg.Fset.PositionFor(wrapped.call.Pos(), false)
+		record(wrapped.pkg, pos, errparser.FuncName(wrapped.pkg, wrapped.call), wrapped.num, g.wrappedArgSource(srcs, wrapped))
+	}
+
+	// The numbers used outside of the parsed errors, e.g. within the ignored functions, are not retired
+	used := maps.Clone(found)
+	for _, wrapped := range g.fileNums {
+		if !siteNodes[wrapped.call] {
+			used[wrapped.num] = true
+		}
+	}
+	// The numbers not found in the files skipped by this run are kept, they may be still used there
+	for num, entry := range entries {
+		if !used[num] && !entry.Retired && g.lastFileParsed(entry) {
+			entry.Retired = true
+		}
+		entries[num] = entry
+	}
+	registry.Errors = slices.SortedFunc(maps.Values(entries), func(a, b RegistryEntry) int {
+		return cmp.Compare(a.Code, b.Code)
+	})
+	g.registry = registry
+
+	// The numbers without a known location are retired if not used
+	g.retired = make(map[int]bool)
+	for num := 1; num <= g.lastErrNum; num++ {
+		entry, ok := entries[num]
+		if !used[num] && (!ok || entry.Retired) {
+			g.retired[num] = true
+		}
+	}
+	return nil
+}
+
+// wrappedExpr returns the source of the i-th error expression wrapped by the site,
+// without the wrapper if it's already wrapped
+func (g *Generator) wrappedExpr(site errparser.ErrorSite, i int) string {
+	switch node := site.Node.(type) {
+	case *ast.ReturnStmt:
+		if site.Kind == errparser.SiteTupleReturn && site.Call != nil {
+			return nodeSource(site, site.Call)
+		}
+		if i < len(site.ErrNames) {
+			return site.ErrNames[i].Name
+		}
+		return ""
+	case ast.Expr:
+		if wrapper, ok := g.asWrapper(site.Pkg, node); ok && len(wrapper.Args) == 2 {
+			return nodeSource(site, wrapper.Args[1])
+		}
+	}
+	return site.Source
+}
+
+// lastFileParsed reports whether the last known file of the entry is parsed in this run or doesn't exist anymore
+func (g *Generator) lastFileParsed(entry RegistryEntry) bool {
+	filename := filepath.FromSlash(entry.File)
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(filepath.Dir(g.RegistryPath()), filename)
+	}
+	if g.parsedFiles[filename] {
+		return true
+	}
+	_, err := g.readFile(filename)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// wrappedArgSource returns the source of the error expression wrapped by the already wrapped error,
+// the file sources are cached in srcs
+func (g *Generator) wrappedArgSource(srcs map[string][]byte, wrapped wrappedNum) string {
+	if len(wrapped.call.Args) != 2 {
+		return ""
+	}
+	start := wrapped.pkg.Fset.PositionFor(wrapped.call.Args[1].Pos(), false)
+	end := wrapped.pkg.Fset.PositionFor(wrapped.call.Args[1].End(), false)
+	src, ok := srcs[start.Filename]
+	if !ok {
+		// An unreadable source is recorded without the expression
+		src, _ = g.readFile(start.Filename)
+		srcs[start.Filename] = src
+	}
+	if end.Offset > len(src) || start.Offset > end.Offset {
+		return ""
+	}
+	return string(src[start.Offset:end.Offset])
+}
+
+// wrapperLines returns the lines of the wrapper calls within the updated file content by their error numbers.
+// The content is not type checked, so the wrappers are recognized like in isOutPackage without the type information:
+// the qualifier must not be declared within the file and must name the import of the output package.
+func (g *Generator) wrapperLines(pkg *packages.Package, filename string, content string) map[int]int {
+	fset := token.NewFileSet()
+	stxFile, err := parser.ParseFile(fset, filename, content, 0)
+	if err != nil {
+		return nil
+	}
+	lines := make(map[int]int)
+	ast.Inspect(stxFile, func(n ast.Node) bool {
+		expr, ok := n.(ast.Expr)
+		if !ok {
+			return true
+		}
+		call, ident, ok := wrapperCall(expr)
+		if !ok || ident.Obj != nil || !g.importsOutPackage(pkg, stxFile, ident) {
+			return true
+		}
+		if num, ok := g.wrapperNum(call); ok {
+			if _, ok := lines[num]; !ok {
+				lines[num] = fset.Position(call.Pos()).Line
+			}
+		}
+		return true
+	})
+	return lines
+}
+
+// nodeSource returns the source of the node found within the site node
+func nodeSource(site errparser.ErrorSite, node ast.Node) string {
+	start, end := int(node.Pos()-site.Node.Pos()), int(node.End()-site.Node.Pos())
+	if start < 0 || end > len(site.Source) || start > end {
+		return ""
+	}
+	return site.Source[start:end]
+}
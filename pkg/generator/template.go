@@ -3,9 +3,215 @@ package generator
 import (
 	// blank import to allow the usage of go:embed
 	_ "embed"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"text/template"
 )
 
 var (
 	//go:embed errnums.tmpl
 	outputFileTemplate []byte
 )
+
+// modulePath is the path of the errnumgen module, used to find its version
+const modulePath = "github.com/anjankow/errnumgen"
+
+// TemplateData is passed to the output templates
+type TemplateData struct {
+	// PackageName is the name of the output package
+	PackageName string
+	// Version is the version of errnumgen generating the files
+	Version string
+	// Options are the generator options, e.g. the code format
+	Options GenOptions
+	// Nums are all issued error numbers in the ascending order, including the retired ones
+	Nums []TemplateNum
+	// Retired are the retired numbers, not used in the source anymore
+	Retired []TemplateNum
+}
+
+// TemplateNum is an issued error number
+type TemplateNum struct {
+	Num int
+	// Name is the name of the const, e.g. N_12
+	Name string
+	// Code is the number as included in the runtime code, e.g. E0012
+	Code string
+	// Retired is set if the number is not used in the source anymore
+	Retired bool
+	// Site is the location of the error wrapped with the number, as recorded in the registry;
+	// nil if not known, e.g. for the numbers skipped by the pinned ones
+	Site *RegistryEntry
+}
+
+// templateFuncs returns the helper functions available in the templates
+func (g *Generator) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// constName returns the name of the const of the number, e.g. N_12
+		"constName": g.constName,
+		// code returns the number as included in the runtime code, e.g. E0012
+		"code": g.code,
+		// quote returns the Go string literal
+		"quote": strconv.Quote,
+		// comment turns the text into the line comments
+		"comment": func(text string) string {
+			return "// " + strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\n// ")
+		},
+		"join":      strings.Join,
+		"lower":     strings.ToLower,
+		"upper":     strings.ToUpper,
+		"replace":   strings.ReplaceAll,
+		"hasPrefix": strings.HasPrefix,
+		"trimPrefix": func(prefix, s string) string {
+			return strings.TrimPrefix(s, prefix)
+		},
+		"add": func(a, b int) int {
+			return a + b
+		},
+	}
+}
+
+// code returns the number as included in the runtime code, e.g. E0012
+func (g *Generator) code(num int) string {
+	return fmt.Sprintf("%s%0*d", g.opts.NumPrefix, g.opts.CodeWidth, num)
+}
+
+// loadTemplates parses the templates of the output files.
+// The template file renders the output file. Each template of the template directory, e.g. codes.go.tmpl,
+// renders the file of the same name without the extension next to the output file, e.g. codes.go;
+// one of them must render the output file. The templates starting with _ are not rendered,
+// they can be used by the other ones.
+func (g *Generator) loadTemplates() error {
+	g.outputs = make(map[string]string)
+	tmpl := template.New("").Funcs(g.templateFuncs()).Option("missingkey=error")
+
+	if g.opts.Template == "" {
+		if _, err := tmpl.New("errnums.tmpl").Parse(string(outputFileTemplate)); err != nil {
+			return fmt.Errorf("failed to parse the output template: %w", err)
+		}
+		g.tmpl = tmpl
+		g.outputs[g.outPathAbs] = "errnums.tmpl"
+		return nil
+	}
+
+	st, err := os.Stat(g.opts.Template)
+	if err != nil {
+		return fmt.Errorf("invalid template path: %w", err)
+	}
+	if !st.IsDir() {
+		if _, err := tmpl.ParseFiles(g.opts.Template); err != nil {
+			return fmt.Errorf("failed to parse the output template: %w", err)
+		}
+		g.tmpl = tmpl
+		g.outputs[g.outPathAbs] = filepath.Base(g.opts.Template)
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(g.opts.Template, "*.tmpl"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no templates found in %s", g.opts.Template)
+	}
+	if _, err := tmpl.ParseFiles(files...); err != nil {
+		return fmt.Errorf("failed to parse the output templates: %w", err)
+	}
+	outDir := filepath.Dir(g.outPathAbs)
+	for _, file := range files {
+		name := filepath.Base(file)
+		if strings.HasPrefix(name, "_") {
+			continue
+		}
+		g.outputs[filepath.Join(outDir, strings.TrimSuffix(name, ".tmpl"))] = name
+	}
+	if _, ok := g.outputs[g.outPathAbs]; !ok {
+		return fmt.Errorf("no template renders the output file, expected %s.tmpl in %s", filepath.Base(g.outPathAbs), g.opts.Template)
+	}
+	g.tmpl = tmpl
+	return nil
+}
+
+// OutputPaths returns the paths of all files rendered by the templates, including the output file
+func (g *Generator) OutputPaths() []string {
+	paths := make([]string, 0, len(g.outputs))
+	for path := range g.outputs {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// genOutputFiles renders the output templates, the Go files are formatted
+func (g *Generator) genOutputFiles() (map[string]string, error) {
+	data := g.templateData()
+	files := make(map[string]string, len(g.outputs))
+	for path, name := range g.outputs {
+		var b strings.Builder
+		if err := g.tmpl.ExecuteTemplate(&b, name, data); err != nil {
+			return nil, fmt.Errorf("failed to execute the template %s: %w", name, err)
+		}
+		content := b.String()
+		if filepath.Ext(path) == ".go" {
+			formatted, err := format.Source([]byte(content))
+			if err != nil {
+				return nil, fmt.Errorf("the template %s renders invalid Go source: %w", name, err)
+			}
+			content = string(formatted)
+		}
+		files[path] = content
+	}
+	return files, nil
+}
+
+// templateData returns the data of the templates, with the numbers located in the registry
+func (g *Generator) templateData() TemplateData {
+	entries := make(map[int]RegistryEntry, len(g.registry.Errors))
+	for _, entry := range g.registry.Errors {
+		entries[entry.Code] = entry
+	}
+
+	data := TemplateData{
+		PackageName: g.opts.OutPackageName,
+		Version:     version(),
+		Options:     g.opts,
+		Nums:        make([]TemplateNum, 0, g.lastErrNum),
+	}
+	for i := range g.lastErrNum {
+		num := TemplateNum{
+			Num:     i + 1,
+			Name:    g.constName(i + 1),
+			Code:    g.code(i + 1),
+			Retired: g.retired[i+1],
+		}
+		if entry, ok := entries[num.Num]; ok {
+			num.Site = &entry
+		}
+		data.Nums = append(data.Nums, num)
+		if num.Retired {
+			data.Retired = append(data.Retired, num)
+		}
+	}
+	return data
+}
+
+// version returns the version of the errnumgen module: (devel) if built from the source, (unknown) if not found
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(unknown)"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return "(unknown)"
+}
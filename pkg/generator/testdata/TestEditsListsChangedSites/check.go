@@ -0,0 +1,22 @@
+package check
+
+import (
+	"errors"
+
+	"example.com/TestEditsListsChangedSites/errnums"
+)
+
+var errSentinel = errors.New("sentinel")
+
+func wrapped() error {
+	return errnums.New(errnums.N_1, errSentinel)
+}
+
+func unwrapped() error {
+	return errSentinel
+}
+
+func repinned() error {
+	//errnumgen:code 5
+	return errnums.New(errnums.N_2, errSentinel)
+}
@@ -0,0 +1,38 @@
+package directives
+
+import (
+	"errors"
+
+	"example.com/TestGenerateAppliesDirectives/errnums"
+)
+
+var errSentinel = errors.New("sentinel")
+
+func first() error {
+	return errors.New("first")
+}
+
+func ignoredReturn() error {
+	//errnumgen:ignore
+	return errors.New("ignored")
+}
+
+// ignoredFunc is skipped entirely
+//
+//errnumgen:ignore
+func ignoredFunc() error {
+	return errors.New("ignored func")
+}
+
+func pinned() error {
+	return errors.New("pinned") //errnumgen:code 2
+}
+
+func repinned() error {
+	//errnumgen:code 42
+	return errnums.New(errnums.N_7, errSentinel)
+}
+
+func last() error {
+	return errSentinel
+}
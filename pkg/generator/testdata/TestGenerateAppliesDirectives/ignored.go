@@ -0,0 +1,9 @@
+package directives
+
+import "errors"
+
+//errnumgen:file-ignore
+
+func ignoredFile() error {
+	return errors.New("ignored file")
+}
@@ -0,0 +1,10 @@
+package format
+
+import "errors"
+
+func pay(amount int) error {
+	if amount < 0 {
+		return errors.New("declined")
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package alpha
+
+import "errors"
+
+func First() error {
+	return errors.New("first")
+}
+
+func Second(ok bool) (int, error) {
+	if !ok {
+		return 0, errors.New("second")
+	}
+	return 1, nil
+}
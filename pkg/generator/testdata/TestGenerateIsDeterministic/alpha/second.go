@@ -0,0 +1,7 @@
+package alpha
+
+import "fmt"
+
+func Third(n int) error {
+	return fmt.Errorf("third: %d", n)
+}
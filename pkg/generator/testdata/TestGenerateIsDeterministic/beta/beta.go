@@ -0,0 +1,13 @@
+package beta
+
+import "errors"
+
+var errSentinel = errors.New("sentinel")
+
+func Fourth() error {
+	return errSentinel
+}
+
+func Fifth() (string, error) {
+	return "", errors.New("fifth")
+}
@@ -0,0 +1,7 @@
+package partial
+
+import "errors"
+
+func a() error {
+	return errors.New("a")
+}
@@ -0,0 +1,7 @@
+package partial
+
+import "errors"
+
+func b() error {
+	return errors.New("b")
+}
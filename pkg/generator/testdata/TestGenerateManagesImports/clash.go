@@ -0,0 +1,10 @@
+package imports
+
+import "fmt"
+
+func count(errnums []int) error {
+	if len(errnums) == 0 {
+		return fmt.Errorf("no errors")
+	}
+	return nil
+}
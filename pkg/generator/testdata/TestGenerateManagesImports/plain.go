@@ -0,0 +1,10 @@
+package imports
+
+import "errors"
+
+func open(name string) error {
+	if name == "" {
+		return errors.New("empty name")
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+package sibling
+
+import "errors"
+
+func a() error {
+	return errors.New("a")
+}
@@ -0,0 +1,4 @@
+package sibling
+
+// errnums is named after the output package, declared in another file than the wrapped errors
+var errnums = 3
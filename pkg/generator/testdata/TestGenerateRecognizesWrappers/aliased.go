@@ -0,0 +1,15 @@
+package wrappers
+
+import (
+	"errors"
+
+	en "example.com/TestGenerateRecognizesWrappers/errnums"
+)
+
+func wrapped() error {
+	return en.New(en.N_4, errors.New("wrapped"))
+}
+
+func unwrapped() error {
+	return errors.New("unwrapped")
+}
@@ -0,0 +1,23 @@
+package wrappers
+
+import (
+	"errors"
+
+	"example.com/TestGenerateRecognizesWrappers/errnums"
+)
+
+type recorder struct{}
+
+func (recorder) New(code int, err error) error {
+	return err
+}
+
+func recorded() error {
+	return errnums.New(errnums.N_1, errors.New("recorded"))
+}
+
+func record(err error) error {
+	// Not the output package, even though named like it and imported
+	errnums := recorder{}
+	return errnums.New(1, err)
+}
@@ -0,0 +1,7 @@
+package prefix
+
+import "errors"
+
+func declined() error {
+	return errors.New("declined")
+}
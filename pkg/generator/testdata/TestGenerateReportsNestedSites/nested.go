@@ -0,0 +1,17 @@
+package nested
+
+import "errors"
+
+func wrap(err error) error {
+	return err
+}
+
+func nested(err error) error {
+	return wrap(func() error {
+		return err
+	}())
+}
+
+func after() error {
+	return errors.New("after")
+}
@@ -0,0 +1,7 @@
+package pins
+
+import "errors"
+
+func pinned() error {
+	return errors.New("pinned") //errnumgen:code 1
+}
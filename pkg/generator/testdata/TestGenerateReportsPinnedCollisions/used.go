@@ -0,0 +1,11 @@
+package pins
+
+import (
+	"errors"
+
+	"example.com/TestGenerateReportsPinnedCollisions/errnums"
+)
+
+func used() error {
+	return errnums.New(errnums.N_1, errors.New("used"))
+}
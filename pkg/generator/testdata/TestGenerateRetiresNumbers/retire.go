@@ -0,0 +1,20 @@
+package retire
+
+import (
+	"errors"
+
+	"example.com/TestGenerateRetiresNumbers/errnums"
+)
+
+func first() error {
+	return errnums.New(errnums.N_1, errors.New("first"))
+}
+
+// The error wrapped with N_3 was removed, N_2 is the highest number left in the source
+func second() error {
+	return errnums.New(errnums.N_2, errors.New("second"))
+}
+
+func added() error {
+	return errors.New("added")
+}
@@ -0,0 +1,14 @@
+package store
+
+import (
+	"errors"
+
+	"example.com/TestGenerateUsesTemplates/errnums"
+)
+
+func Get(key string) error {
+	if key == "" {
+		return errnums.New(errnums.N_1, errors.New("empty key"))
+	}
+	return errors.New("not found")
+}
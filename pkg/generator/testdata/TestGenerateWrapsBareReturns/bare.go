@@ -0,0 +1,26 @@
+package bare
+
+import (
+	"errors"
+	"strconv"
+)
+
+func parse(s string) (n int, err error) {
+	n, err = strconv.Atoi(s)
+	if n < 0 {
+		err = errors.New("negative")
+		return
+	}
+	return
+}
+
+func pick(i int) (s string, err error) {
+	switch i {
+	case 0:
+		s = "zero"
+	default:
+		err = errors.New("unknown")
+		return
+	}
+	return
+}
@@ -0,0 +1,18 @@
+package deferred
+
+import "os"
+
+func write(name string) (err error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	_, err = f.WriteString("data")
+	return err
+}
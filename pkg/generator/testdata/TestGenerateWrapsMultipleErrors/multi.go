@@ -0,0 +1,18 @@
+package multi
+
+import "errors"
+
+func validate(s string) (warn, err error) {
+	if s == "" {
+		return errors.New("empty"), errors.New("invalid")
+	}
+	if len(s) > 10 {
+		warn = errors.New("long")
+		return
+	}
+	return nil, nil
+}
+
+func validateTwice(s string) (error, error) {
+	return validate(s + s)
+}
@@ -0,0 +1,29 @@
+package tuple
+
+import "errors"
+
+type Config struct {
+	Name string
+}
+
+func loadConfig(path string) (Config, error) {
+	if path == "" {
+		return Config{}, errors.New("empty path")
+	}
+	return Config{Name: path}, nil
+}
+
+func Load(path string) (Config, error) {
+	return loadConfig(path)
+}
+
+func LoadDefault(path string) (cfg Config, err error) {
+	if path == "" {
+		return (loadConfig("default"))
+	}
+	return
+}
+
+func LoadNamed(path string) (r0 Config, err error) {
+	return loadConfig(path)
+}
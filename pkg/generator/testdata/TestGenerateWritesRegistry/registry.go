@@ -0,0 +1,33 @@
+package registry
+
+import (
+	"errors"
+
+	"example.com/TestGenerateWritesRegistry/errnums"
+)
+
+// tracer is used under the name of the output package, its calls are not the wrappers
+type tracer struct {
+	N_2 int
+}
+
+func (tracer) New(code int, err error) {}
+
+func trace(err error) {
+	errnums := tracer{}
+	errnums.New(errnums.N_2, err)
+}
+
+type Store struct{}
+
+func (s *Store) Get(key string) error {
+	if key == "" {
+		return errnums.New(errnums.N_1, errors.New("empty key"))
+	}
+	return errors.New("not found")
+}
+
+func load() (n int, err error) {
+	err = errors.New("not loaded")
+	return
+}